@@ -8,5 +8,19 @@ import (
 
 // Adapter interface for receiving real-time updates
 type Adapter interface {
+	// Connect calls the handler for each change until the context is done,
+	// then returns
 	Connect(ctx context.Context, handler func(ctx context.Context, data types.Resource)) error
 }
+
+// Acknowledger is implemented by adapters that report the resource versions
+// the agent handled back to the operator
+type Acknowledger interface {
+	Ack(ctx context.Context, ack types.Ack) error
+}
+
+// ProjectLister is implemented by adapters that know the projects they
+// subscribe to
+type ProjectLister interface {
+	Projects() []string
+}
@@ -1,18 +1,37 @@
 package agent
 
 import (
+	"bytes"
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/lamlv2305/sentinel/types"
 	"github.com/r3labs/sse/v2"
+	"gopkg.in/cenkalti/backoff.v1"
 )
 
 var _ Adapter = &SSEAdapter{}
 
+var (
+	// ErrRevoked is returned by Connect when the operator revoked the connection
+	ErrRevoked = errors.New("agent: connection revoked by operator")
+	// ErrUnauthorized is returned by Connect when the operator rejects the credentials
+	ErrUnauthorized = errors.New("agent: unauthorized")
+)
+
 // SSEEvent represents a parsed server-sent event
 type SSEEvent struct {
 	Type string
@@ -23,8 +42,16 @@ type SSEAdapter struct {
 	endpoint   string
 	maxRetries int // 0 means infinite retries
 	retryDelay time.Duration
+	headers    map[string]string
+	query      url.Values
+	metadata   types.AgentMetadata
+	ackURL     string
+	tlsConfig  *tls.Config
 	client     *sse.Client
 	logger     *slog.Logger
+
+	mu           sync.Mutex
+	connectionId string // id of the current connection, sent by the operator
 }
 
 func NewSSEAdapter(endpoint string, opts ...SSEAdapterOption) *SSEAdapter {
@@ -32,20 +59,98 @@ func NewSSEAdapter(endpoint string, opts ...SSEAdapterOption) *SSEAdapter {
 		endpoint:   endpoint,
 		maxRetries: 0, // 0 means infinite retries by default
 		retryDelay: 5 * time.Second,
+		headers:    make(map[string]string),
+		query:      make(url.Values),
 		client:     sse.NewClient(endpoint),
 		logger:     slog.Default(),
 	}
 
+	if hostname, err := os.Hostname(); err == nil {
+		adapter.metadata.Hostname = hostname
+	}
+
 	for _, opt := range opts {
 		opt(adapter)
 	}
 
+	if !adapter.metadata.IsZero() {
+		adapter.headers[types.HeaderAgent] = adapter.metadata.Encode()
+	}
+
+	for key, value := range adapter.headers {
+		adapter.client.Headers[key] = value
+	}
+
+	if len(adapter.query) > 0 {
+		separator := "?"
+		if strings.Contains(endpoint, "?") {
+			separator = "&"
+		}
+		adapter.client.URL = endpoint + separator + adapter.query.Encode()
+	}
+
+	if adapter.tlsConfig != nil {
+		adapter.client.Connection = &http.Client{
+			Transport: &http.Transport{
+				Proxy:           http.ProxyFromEnvironment,
+				TLSClientConfig: adapter.tlsConfig,
+			},
+		}
+	}
+
+	// Rejected credentials will not become valid by retrying
+	adapter.client.ResponseValidator = func(c *sse.Client, resp *http.Response) error {
+		switch resp.StatusCode {
+		case http.StatusOK:
+			return nil
+		case http.StatusUnauthorized, http.StatusForbidden:
+			resp.Body.Close()
+			return backoff.Permanent(ErrUnauthorized)
+		default:
+			resp.Body.Close()
+			return errors.New("could not connect to stream: " + resp.Status)
+		}
+	}
+
 	return adapter
 }
 
-// Connect implements Adapter.
+// Connect implements Adapter. It reconnects until the context is done, the
+// retries are exhausted, or the operator rejects or revokes the connection.
 func (s *SSEAdapter) Connect(ctx context.Context, handler func(ctx context.Context, data types.Resource)) error {
-	return s.client.SubscribeRaw(func(msg *sse.Event) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	var strategy backoff.BackOff = backoff.NewConstantBackOff(s.retryDelay)
+	if s.maxRetries > 0 {
+		strategy = backoff.WithMaxTries(strategy, uint64(s.maxRetries))
+	}
+	s.client.ReconnectStrategy = backoff.WithContext(strategy, ctx)
+
+	err := s.client.SubscribeRawWithContext(ctx, func(msg *sse.Event) {
+		if types.ControlType(msg.Event) == types.ControlRevoked {
+			s.logger.Warn("Connection revoked by operator", "reason", string(msg.Data))
+			cancel(ErrRevoked)
+			return
+		}
+
+		if types.ControlType(msg.Event) == types.ControlConnected {
+			var connected struct {
+				Id string `json:"id"`
+			}
+			if err := json.Unmarshal(msg.Data, &connected); err == nil {
+				s.mu.Lock()
+				s.connectionId = connected.Id
+				s.mu.Unlock()
+			}
+			return
+		}
+
+		if types.ControlType(msg.Event) == types.ControlDisconnected {
+			s.logger.Warn("Connection closed by operator, reconnecting", "reason", string(msg.Data))
+			return
+		}
+
 		bytes, err := base64.StdEncoding.DecodeString(string(msg.Data))
 		if err != nil {
 			return
@@ -57,10 +162,66 @@ func (s *SSEAdapter) Connect(ctx context.Context, handler func(ctx context.Conte
 			return
 		}
 
-		s.logger.Debug("Received SSE event",
-			"resource", ce.Resource,
-			"data", string(ce.Resource.Data))
+		s.logger.Debug("Received SSE event", "resource", ce.Resource)
+
+		handler(ctx, ce.Resource)
 	})
+
+	if cause := context.Cause(ctx); errors.Is(cause, ErrRevoked) {
+		return ErrRevoked
+	}
+
+	return err
+}
+
+// Projects implements ProjectLister.
+func (s *SSEAdapter) Projects() []string {
+	var projects []string
+	for _, project := range strings.Split(s.headers[types.HeaderProject], ",") {
+		if project = strings.TrimSpace(project); project != "" {
+			projects = append(projects, project)
+		}
+	}
+
+	return projects
+}
+
+// Ack implements Acknowledger. It posts the ack to the endpoint set with
+// WithAckEndpoint, authenticated like the stream.
+func (s *SSEAdapter) Ack(ctx context.Context, ack types.Ack) error {
+	if s.ackURL == "" {
+		return nil
+	}
+
+	s.mu.Lock()
+	ack.ConnectionId = s.connectionId
+	s.mu.Unlock()
+
+	body, err := json.Marshal(ack)
+	if err != nil {
+		return fmt.Errorf("failed to marshal ack: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.ackURL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create ack request: %w", err)
+	}
+	for key, value := range s.client.Headers {
+		req.Header.Set(key, value)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.client.Connection.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send ack: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("failed to send ack: %s", resp.Status)
+	}
+
+	return nil
 }
 
 // SSEAdapterOption configures the SSE adapter
@@ -80,6 +241,144 @@ func WithRetryDelay(delay time.Duration) SSEAdapterOption {
 	}
 }
 
+// WithAPIKey authenticates with an apikey sent as a bearer token
+func WithAPIKey(apikey string) SSEAdapterOption {
+	return func(s *SSEAdapter) {
+		s.headers["Authorization"] = "Bearer " + apikey
+	}
+}
+
+// WithProject sets the project to subscribe to
+func WithProject(project string) SSEAdapterOption {
+	return func(s *SSEAdapter) {
+		s.headers[types.HeaderProject] = project
+	}
+}
+
+// WithProjects subscribes to several projects over one connection; events
+// carry their project in Resource.ProjectId
+func WithProjects(projects ...string) SSEAdapterOption {
+	return func(s *SSEAdapter) {
+		s.headers[types.HeaderProject] = strings.Join(projects, ",")
+	}
+}
+
+// WithLabels declares labels the operator can target the agent by
+func WithLabels(labels map[string]string) SSEAdapterOption {
+	return func(s *SSEAdapter) {
+		pairs := make([]string, 0, len(labels))
+		for key, value := range labels {
+			pairs = append(pairs, key+"="+value)
+		}
+		slices.Sort(pairs)
+		s.headers[types.HeaderLabels] = strings.Join(pairs, ",")
+	}
+}
+
+// WithAgentInfo describes the agent to the operator, which can target agents
+// by app and version. The hostname is sent by default.
+func WithAgentInfo(app, version string) SSEAdapterOption {
+	return func(s *SSEAdapter) {
+		s.metadata.App = app
+		s.metadata.Version = version
+	}
+}
+
+// WithHostname overrides the hostname sent to the operator
+func WithHostname(hostname string) SSEAdapterOption {
+	return func(s *SSEAdapter) {
+		s.metadata.Hostname = hostname
+	}
+}
+
+// WithCapabilities declares features the agent supports
+func WithCapabilities(capabilities ...string) SSEAdapterOption {
+	return func(s *SSEAdapter) {
+		s.metadata.Capabilities = append(s.metadata.Capabilities, capabilities...)
+	}
+}
+
+// WithAckEndpoint acknowledges every resource version the agent handles to
+// the operator's ack endpoint, so rollouts can be tracked
+func WithAckEndpoint(endpoint string) SSEAdapterOption {
+	return func(s *SSEAdapter) {
+		s.ackURL = endpoint
+		if !s.metadata.HasCapability(types.CapabilityAck) {
+			s.metadata.Capabilities = append(s.metadata.Capabilities, types.CapabilityAck)
+		}
+	}
+}
+
+// WithCoalescing asks the operator to send only the latest pending event per
+// resource when the agent falls behind, instead of disconnecting it
+func WithCoalescing() SSEAdapterOption {
+	return func(s *SSEAdapter) {
+		s.headers[types.HeaderCoalesce] = "true"
+	}
+}
+
+// WithGroups subscribes only to the given group patterns: exact names, globs
+// such as "payments/*", or prefixes such as "payments/**"
+func WithGroups(patterns ...string) SSEAdapterOption {
+	return func(s *SSEAdapter) {
+		s.query["group"] = append(s.query["group"], patterns...)
+	}
+}
+
+// WithResourceIds subscribes only to the given resources
+func WithResourceIds(ids ...string) SSEAdapterOption {
+	return func(s *SSEAdapter) {
+		s.query["resource"] = append(s.query["resource"], ids...)
+	}
+}
+
+// WithResourceTypes subscribes only to resources of the given types
+func WithResourceTypes(resourceTypes ...types.ResourceType) SSEAdapterOption {
+	return func(s *SSEAdapter) {
+		for _, resourceType := range resourceTypes {
+			s.query.Add("type", string(resourceType))
+		}
+	}
+}
+
+// WithHeader sends a custom header on every connection attempt
+func WithHeader(key, value string) SSEAdapterOption {
+	return func(s *SSEAdapter) {
+		s.headers[key] = value
+	}
+}
+
+// WithTLSConfig sets the TLS configuration used to reach the operator
+func WithTLSConfig(config *tls.Config) SSEAdapterOption {
+	return func(s *SSEAdapter) {
+		s.tlsConfig = config
+	}
+}
+
+// WithClientCertificate authenticates with a TLS client certificate, e.g.
+// one loaded with tls.LoadX509KeyPair
+func WithClientCertificate(cert tls.Certificate) SSEAdapterOption {
+	return func(s *SSEAdapter) {
+		cfg := s.ensureTLSConfig()
+		cfg.Certificates = append(cfg.Certificates, cert)
+	}
+}
+
+// WithRootCAs trusts a custom CA pool for the operator's certificate
+func WithRootCAs(pool *x509.CertPool) SSEAdapterOption {
+	return func(s *SSEAdapter) {
+		s.ensureTLSConfig().RootCAs = pool
+	}
+}
+
+func (s *SSEAdapter) ensureTLSConfig() *tls.Config {
+	if s.tlsConfig == nil {
+		s.tlsConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+
+	return s.tlsConfig
+}
+
 // WithLogger sets a custom logger
 func WithLogger(logger *slog.Logger) SSEAdapterOption {
 	return func(s *SSEAdapter) {
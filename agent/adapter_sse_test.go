@@ -0,0 +1,135 @@
+package agent
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/lamlv2305/sentinel/operator"
+	"github.com/lamlv2305/sentinel/types"
+)
+
+// newClientCertificate returns a CA pool and a client certificate it issued
+// with the URI SAN
+func newClientCertificate(t *testing.T, san string) (*x509.CertPool, tls.Certificate) {
+	t.Helper()
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "sentinel test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ca, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	uri, err := url.Parse(san)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "agent"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		URIs:         []*url.URL{uri},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+
+	return pool, tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestSSEAdapterClientCertificate(t *testing.T) {
+	const san = "spiffe://corp/projects/billing/agents/a1"
+	clientCAs, cert := newClientCertificate(t, san)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	mapper, err := operator.NewSANMapper(operator.SANRule{
+		Pattern:     "spiffe://corp/projects/{project}/agents/*",
+		Permissions: []operator.Permission{operator.PermissionSubscribe},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mux := http.NewServeMux()
+	sse := operator.NewSSE(mux, "/sse", operator.WithSSEClientCertAuth(mapper))
+	go sse.Run(ctx)
+
+	server := httptest.NewUnstartedServer(mux)
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	server.StartTLS()
+	defer server.Close()
+	defer server.CloseClientConnections() // the stream stays open otherwise
+
+	roots := x509.NewCertPool()
+	roots.AddCert(server.Certificate())
+
+	adapter := NewSSEAdapter(server.URL+"/sse",
+		WithProject("billing"),
+		WithClientCertificate(cert),
+		WithRootCAs(roots),
+		WithMaxRetries(1),
+	)
+	go adapter.Connect(ctx, func(ctx context.Context, data types.Resource) {})
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		connections := sse.Connections("billing", operator.Selector{})
+		if len(connections) == 1 {
+			principal := connections[0].Principal
+			if principal == nil || principal.Subject != san {
+				t.Fatalf("principal = %+v, want subject %q", principal, san)
+			}
+			return
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatal("agent did not connect with its client certificate")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
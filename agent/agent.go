@@ -2,14 +2,25 @@ package agent
 
 import (
 	"context"
+	"fmt"
 	"log/slog"
+	"sync"
 
+	"github.com/lamlv2305/sentinel/persister"
 	"github.com/lamlv2305/sentinel/types"
 )
 
 // Agent represents the main agent for interacting with resgate
 type Agent struct {
 	*Options
+
+	// namespaced tells whether resources are kept in the shared persister
+	// under ids prefixed with their project, see persisterFor
+	namespaced     bool
+	defaultProject string // first subscribed project, read by Get
+
+	mu         sync.Mutex
+	rejections map[string]Rejection // by project and resource id
 }
 
 // New creates a new Resagent instance
@@ -20,49 +31,173 @@ func New(opts ...Option) *Agent {
 	}
 
 	ra := &Agent{
-		Options: options,
+		Options:    options,
+		rejections: make(map[string]Rejection),
 	}
 
 	if ra.adapter == nil {
 		slog.Warn("Adapter is not set")
 	}
 
-	if ra.persister == nil {
+	if lister, ok := ra.adapter.(ProjectLister); ok {
+		projects := lister.Projects()
+		if len(projects) > 0 {
+			ra.defaultProject = projects[0]
+		}
+		ra.namespaced = len(projects) > 1
+	}
+
+	if ra.persister == nil && len(ra.projects) == 0 {
 		slog.Warn("Persister is not set")
 	}
 
 	return ra
 }
 
-// Start begins the resagent operations
+// Run connects the adapter and hands it the changes to process, until the
+// context is done or the adapter fails. The adapter's Connect must return once
+// the context is done: Run waits for it before closing the subscriber channel.
 func (ra *Agent) Run(ctx context.Context) error {
 	errCh := make(chan error, 1)
-	defer close(errCh)
-	defer close(ra.subscriber)
-
 	go func() {
 		errCh <- ra.adapter.Connect(ctx, ra.handleDataChange)
 	}()
 
-	for {
-		select {
-		case <-ctx.Done():
-			return nil
+	err := <-errCh
+	close(ra.subscriber)
 
-		case err := <-errCh:
-			return err
-		}
+	if ctx.Err() != nil {
+		return nil
+	}
+
+	return err
+}
+
+// Get returns a resource of the first subscribed project from the persister,
+// decrypting it when the agent persists sealed resources. Agents subscribed
+// to several projects use GetFromProject for the others.
+func (ra *Agent) Get(ctx context.Context, id string) (types.Resource, error) {
+	return ra.GetFromProject(ctx, ra.defaultProject, id)
+}
+
+// GetFromProject is Get for the persister of the given project
+func (ra *Agent) GetFromProject(ctx context.Context, projectId string, id string) (types.Resource, error) {
+	p, err := ra.persisterFor(projectId)
+	if err != nil {
+		return types.Resource{}, err
+	}
+
+	resource, err := p.Get(ctx, id)
+	if err != nil {
+		return resource, err
+	}
+
+	return ra.open(ctx, resource)
+}
+
+// persisterFor returns the persister of the project, or the shared one. When
+// the agent subscribes to several projects, the shared persister keeps each
+// project's resources under ids prefixed with the project, so the same
+// resource id in two projects does not collide.
+func (ra *Agent) persisterFor(projectId string) (persister.Persister[types.Resource], error) {
+	if p, ok := ra.projects[projectId]; ok {
+		return p, nil
+	}
+
+	if ra.persister == nil {
+		return nil, fmt.Errorf("%w %s", ErrNoPersister, projectId)
+	}
+
+	if ra.namespaced {
+		return projectPersister{projectId: projectId, shared: ra.persister}, nil
 	}
+
+	return ra.persister, nil
 }
 
 // handleDataChange processes incoming data changes from resgate
 func (ra *Agent) handleDataChange(ctx context.Context, data types.Resource) {
+	// Validators and DecryptOnReceive need the plaintext before persisting
+	opened, err := ra.open(ctx, data)
+	if err != nil {
+		slog.Error("Failed to decrypt resource, keeping the last good value", "resourceId", data.ResourceId, "version", data.Version, "error", err)
+		ra.rejected(data, err)
+		ra.ack(ctx, data, types.AckRejected, err)
+		return
+	}
+
+	// Without a sealer the data is still ciphertext, which validators cannot
+	// check, so resources they apply to are rejected rather than trusted
+	if opened.IsSealed() && ra.validates(opened) {
+		err = ErrSealedUnvalidated
+	} else {
+		err = ra.validate(ctx, opened)
+	}
+	if err != nil {
+		slog.Warn("Rejected resource, keeping the last good value", "resourceId", data.ResourceId, "version", data.Version, "error", err)
+		ra.rejected(data, err)
+		ra.ack(ctx, data, types.AckRejected, err)
+		return
+	}
+
+	stored := data
+	if ra.decryptMode == DecryptOnReceive {
+		stored = opened
+	}
+
 	// Update cache
-	ra.persister.Save(ctx, data)
+	p, err := ra.persisterFor(stored.ProjectId)
+	if err == nil {
+		err = p.Save(ctx, stored)
+	}
+	if err != nil {
+		slog.Error("Failed to persist resource", "resourceId", stored.ResourceId, "error", err)
+		ra.ack(ctx, stored, types.AckFailed, err)
+	} else {
+		ra.accepted(stored)
+		ra.ack(ctx, stored, types.AckApplied, nil)
+	}
 
 	select {
-	case ra.subscriber <- data:
+	case ra.subscriber <- opened:
 	default:
 		// If the subscriber channel is full, we skip sending the update
 	}
 }
+
+// ack reports the outcome of handling a resource version in the background,
+// when the adapter acknowledges
+func (ra *Agent) ack(ctx context.Context, resource types.Resource, status types.AckStatus, cause error) {
+	acknowledger, ok := ra.adapter.(Acknowledger)
+	if !ok || resource.Version == "" {
+		return
+	}
+
+	ack := types.Ack{
+		ProjectId:  resource.ProjectId,
+		ResourceId: resource.ResourceId,
+		Version:    resource.Version,
+		Status:     status,
+	}
+	if cause != nil {
+		ack.Error = cause.Error()
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), ra.timeout)
+		defer cancel()
+
+		if err := acknowledger.Ack(ctx, ack); err != nil {
+			slog.Warn("Failed to acknowledge resource version", "resourceId", ack.ResourceId, "version", ack.Version, "error", err)
+		}
+	}()
+}
+
+// open decrypts a sealed resource; plain resources are returned untouched
+func (ra *Agent) open(ctx context.Context, resource types.Resource) (types.Resource, error) {
+	if ra.sealer == nil || !resource.IsSealed() {
+		return resource, nil
+	}
+
+	return ra.sealer.Open(ctx, resource)
+}
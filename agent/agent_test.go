@@ -0,0 +1,266 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/lamlv2305/sentinel/envelope"
+	"github.com/lamlv2305/sentinel/types"
+)
+
+var errNotFound = errors.New("not found")
+
+// memoryPersister keeps resources in a map, listed in id order
+type memoryPersister struct {
+	mu    sync.Mutex
+	items map[string]types.Resource
+}
+
+func newMemoryPersister() *memoryPersister {
+	return &memoryPersister{items: make(map[string]types.Resource)}
+}
+
+func (p *memoryPersister) Save(ctx context.Context, item types.Resource) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.items[item.Id()] = item
+	return nil
+}
+
+func (p *memoryPersister) Delete(ctx context.Context, id string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	delete(p.items, id)
+	return nil
+}
+
+func (p *memoryPersister) Get(ctx context.Context, id string) (types.Resource, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	item, ok := p.items[id]
+	if !ok {
+		return item, errNotFound
+	}
+	return item, nil
+}
+
+func (p *memoryPersister) List(ctx context.Context, offset, limit int) ([]types.Resource, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	ids := slices.Sorted(maps.Keys(p.items))
+	ids = ids[min(offset, len(ids)):]
+	ids = ids[:min(limit, len(ids))]
+
+	items := make([]types.Resource, 0, len(ids))
+	for _, id := range ids {
+		items = append(items, p.items[id])
+	}
+	return items, nil
+}
+
+// projectsAdapter is an adapter subscribed to fixed projects
+type projectsAdapter []string
+
+func (a projectsAdapter) Connect(ctx context.Context, handler func(ctx context.Context, data types.Resource)) error {
+	<-ctx.Done()
+	return nil
+}
+
+func (a projectsAdapter) Projects() []string {
+	return a
+}
+
+func TestAgentSharedPersister(t *testing.T) {
+	ctx := context.Background()
+	shared := newMemoryPersister()
+	a := New(WithAdapter(projectsAdapter{"billing", "payroll"}), WithPersister(shared))
+
+	a.handleDataChange(ctx, types.Resource{ProjectId: "billing", ResourceId: "config", Data: []byte("b")})
+	a.handleDataChange(ctx, types.Resource{ProjectId: "payroll", ResourceId: "config", Data: []byte("p")})
+
+	for project, want := range map[string]string{"billing": "b", "payroll": "p"} {
+		got, err := a.GetFromProject(ctx, project, "config")
+		if err != nil {
+			t.Fatalf("GetFromProject(%s): %v", project, err)
+		}
+		if string(got.Data) != want || got.ResourceId != "config" || got.ProjectId != project {
+			t.Errorf("GetFromProject(%s) = %+v, want data %q", project, got, want)
+		}
+	}
+
+	got, err := a.Get(ctx, "config")
+	if err != nil || string(got.Data) != "b" {
+		t.Errorf("Get = %+v, %v, want the first project's resource", got, err)
+	}
+
+	listed, err := projectPersister{projectId: "payroll", shared: shared}.List(ctx, 0, 10)
+	if err != nil || len(listed) != 1 || listed[0].ResourceId != "config" || string(listed[0].Data) != "p" {
+		t.Errorf("List = %+v, %v, want the payroll resource only", listed, err)
+	}
+}
+
+func TestAgentSingleProjectPersister(t *testing.T) {
+	ctx := context.Background()
+	shared := newMemoryPersister()
+	a := New(WithAdapter(projectsAdapter{"billing"}), WithPersister(shared))
+
+	a.handleDataChange(ctx, types.Resource{ProjectId: "billing", ResourceId: "config", Data: []byte("b")})
+
+	// A single project keeps plain ids, as before projects were namespaced
+	if _, err := shared.Get(ctx, "config"); err != nil {
+		t.Fatalf("resource not stored under its plain id: %v", err)
+	}
+}
+
+func TestAgentGetWithProjectPersisterOnly(t *testing.T) {
+	ctx := context.Background()
+	billing := newMemoryPersister()
+	a := New(WithAdapter(projectsAdapter{"billing"}), WithProjectPersister("billing", billing))
+
+	a.handleDataChange(ctx, types.Resource{ProjectId: "billing", ResourceId: "config", Data: []byte("b")})
+
+	got, err := a.Get(ctx, "config")
+	if err != nil || string(got.Data) != "b" {
+		t.Fatalf("Get = %+v, %v", got, err)
+	}
+
+	if _, err := a.GetFromProject(ctx, "payroll", "config"); !errors.Is(err, ErrNoPersister) {
+		t.Fatalf("GetFromProject without persister: error = %v, want %v", err, ErrNoPersister)
+	}
+}
+
+// ackAdapter records the acks of the agent
+type ackAdapter struct {
+	projectsAdapter
+	acks chan types.Ack
+}
+
+func (a ackAdapter) Ack(ctx context.Context, ack types.Ack) error {
+	a.acks <- ack
+	return nil
+}
+
+func TestAgentRejectsUndecryptable(t *testing.T) {
+	ctx := context.Background()
+
+	operatorKeys, err := envelope.NewFileKeyProvider(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := envelope.NewSealer(operatorKeys).Seal(ctx, types.Resource{ProjectId: "billing", ResourceId: "config", Version: "v1", Data: []byte("secret")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The agent's keys differ from the operator's
+	agentKeys, err := envelope.NewFileKeyProvider(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, mode := range []DecryptMode{DecryptOnReceive, DecryptOnRead} {
+		adapter := ackAdapter{projectsAdapter: projectsAdapter{"billing"}, acks: make(chan types.Ack, 1)}
+		store := newMemoryPersister()
+		a := New(WithAdapter(adapter), WithPersister(store), WithSealer(envelope.NewSealer(agentKeys), mode))
+
+		a.handleDataChange(ctx, sealed)
+
+		if ack := <-adapter.acks; ack.Status != types.AckRejected || ack.Version != "v1" {
+			t.Errorf("mode %d: ack = %+v, want v1 rejected", mode, ack)
+		}
+		if rejections := a.Status().Rejections; len(rejections) != 1 || rejections[0].Version != "v1" {
+			t.Errorf("mode %d: rejections = %+v, want v1", mode, rejections)
+		}
+		if _, err := store.Get(ctx, "config"); !errors.Is(err, errNotFound) {
+			t.Errorf("mode %d: undecryptable resource was persisted", mode)
+		}
+	}
+}
+
+func TestAgentRejectsSealedWithoutSealer(t *testing.T) {
+	ctx := context.Background()
+
+	keys, err := envelope.NewFileKeyProvider(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := envelope.NewSealer(keys).Seal(ctx, types.Resource{ProjectId: "billing", ResourceId: "config", Group: "payments", Version: "v1", Data: []byte("secret")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		option   Option
+		rejected bool
+	}{
+		{name: "validator applies", option: WithGroupValidator("payments", func(ctx context.Context, resource types.Resource) error { return nil }), rejected: true},
+		{name: "validator does not apply", option: WithResourceValidator("other", func(ctx context.Context, resource types.Resource) error { return nil })},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			adapter := ackAdapter{projectsAdapter: projectsAdapter{"billing"}, acks: make(chan types.Ack, 1)}
+			store := newMemoryPersister()
+			a := New(WithAdapter(adapter), WithPersister(store), tt.option)
+
+			a.handleDataChange(ctx, sealed)
+
+			want := types.AckApplied
+			if tt.rejected {
+				want = types.AckRejected
+			}
+			if ack := <-adapter.acks; ack.Status != want {
+				t.Errorf("ack = %+v, want %s", ack, want)
+			}
+
+			_, err := store.Get(ctx, "config")
+			if persisted := err == nil; persisted == tt.rejected {
+				t.Errorf("persisted = %v, want %v", persisted, !tt.rejected)
+			}
+			if rejections := a.Status().Rejections; (len(rejections) == 1) != tt.rejected {
+				t.Errorf("rejections = %+v", rejections)
+			}
+		})
+	}
+}
+
+// streamAdapter hands the agent changes until the context is done
+type streamAdapter struct{}
+
+func (streamAdapter) Connect(ctx context.Context, handler func(ctx context.Context, data types.Resource)) error {
+	for ctx.Err() == nil {
+		handler(ctx, types.Resource{ProjectId: "billing", ResourceId: "config", Data: []byte("b")})
+	}
+	return ctx.Err()
+}
+
+func TestAgentRunShutdown(t *testing.T) {
+	for range 200 {
+		subscriber := make(chan types.Resource, 1)
+		a := New(WithAdapter(streamAdapter{}), WithPersister(newMemoryPersister()), WithSubscriber(subscriber))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error, 1)
+		go func() { done <- a.Run(ctx) }()
+
+		<-subscriber
+		cancel()
+
+		if err := <-done; err != nil {
+			t.Fatalf("Run = %v, want nil after cancel", err)
+		}
+
+		// The subscriber is closed once the adapter stopped handling changes
+		for range subscriber {
+		}
+	}
+}
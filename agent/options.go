@@ -3,6 +3,7 @@ package agent
 import (
 	"time"
 
+	"github.com/lamlv2305/sentinel/envelope"
 	"github.com/lamlv2305/sentinel/persister"
 	"github.com/lamlv2305/sentinel/types"
 )
@@ -12,10 +13,24 @@ type Options struct {
 	timeout        time.Duration
 	reconnectDelay time.Duration
 	persister      persister.Persister[types.Resource]
+	projects       map[string]persister.Persister[types.Resource] // per project, overriding persister
 	adapter        Adapter
 	subscriber     chan types.Resource // Channel for receiving updates
+	sealer         *envelope.Sealer
+	decryptMode    DecryptMode
+	validators     []validator
 }
 
+// DecryptMode controls when sealed resources are decrypted by the agent
+type DecryptMode int
+
+const (
+	// DecryptOnReceive decrypts before persisting, so the persister holds plaintext
+	DecryptOnReceive DecryptMode = iota
+	// DecryptOnRead persists the sealed resource and decrypts it in Agent.Get
+	DecryptOnRead
+)
+
 // Option is a function that configures Options
 type Option func(*Options)
 
@@ -27,6 +42,7 @@ func defaultOptions() *Options {
 		persister:      nil,                          // Will be set later
 		adapter:        nil,                          // Will be set later
 		subscriber:     make(chan types.Resource, 1), // Buffered channel for updates
+		projects:       make(map[string]persister.Persister[types.Resource]),
 	}
 }
 
@@ -44,13 +60,23 @@ func WithReconnectDelay(delay time.Duration) Option {
 	}
 }
 
-// WithPersister sets the cache implementation
+// WithPersister sets the cache implementation. When the adapter subscribes to
+// several projects, resources of projects without a WithProjectPersister are
+// kept in it under ids prefixed with their project, e.g. "billing/config".
 func WithPersister(cache persister.Persister[types.Resource]) Option {
 	return func(o *Options) {
 		o.persister = cache
 	}
 }
 
+// WithProjectPersister keeps the resources of one project, for agents
+// subscribed to several, apart from those of the others
+func WithProjectPersister(projectId string, cache persister.Persister[types.Resource]) Option {
+	return func(o *Options) {
+		o.projects[projectId] = cache
+	}
+}
+
 // WithAdapter sets the adapter implementation
 func WithAdapter(adapter Adapter) Option {
 	return func(o *Options) {
@@ -63,3 +89,11 @@ func WithSubscriber(subscriber chan types.Resource) Option {
 		o.subscriber = subscriber
 	}
 }
+
+// WithSealer sets the sealer used to decrypt resources sealed by the operator
+func WithSealer(sealer *envelope.Sealer, mode DecryptMode) Option {
+	return func(o *Options) {
+		o.sealer = sealer
+		o.decryptMode = mode
+	}
+}
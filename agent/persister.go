@@ -0,0 +1,80 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/lamlv2305/sentinel/persister"
+	"github.com/lamlv2305/sentinel/types"
+)
+
+var ErrNoPersister = errors.New("agent: no persister for project")
+
+// projectPersister keeps the resources of one project in a persister shared
+// with other projects, under ids prefixed with the project
+type projectPersister struct {
+	projectId string
+	shared    persister.Persister[types.Resource]
+}
+
+var _ persister.Persister[types.Resource] = projectPersister{}
+
+func (p projectPersister) key(id string) string {
+	return p.projectId + "/" + id
+}
+
+func (p projectPersister) Save(ctx context.Context, item types.Resource) error {
+	item.ResourceId = p.key(item.ResourceId)
+	return p.shared.Save(ctx, item)
+}
+
+func (p projectPersister) Delete(ctx context.Context, id string) error {
+	return p.shared.Delete(ctx, p.key(id))
+}
+
+func (p projectPersister) Get(ctx context.Context, id string) (types.Resource, error) {
+	item, err := p.shared.Get(ctx, p.key(id))
+	if err != nil {
+		return item, err
+	}
+
+	item.ResourceId = id
+	return item, nil
+}
+
+// List pages through the shared persister, skipping other projects
+func (p projectPersister) List(ctx context.Context, offset, limit int) ([]types.Resource, error) {
+	const batchSize = 100
+
+	var items []types.Resource
+	for position := 0; len(items) < limit; position += batchSize {
+		batch, err := p.shared.List(ctx, position, batchSize)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, item := range batch {
+			id, ok := strings.CutPrefix(item.ResourceId, p.key(""))
+			if !ok {
+				continue
+			}
+			if offset > 0 {
+				offset--
+				continue
+			}
+
+			item.ResourceId = id
+			items = append(items, item)
+			if len(items) == limit {
+				break
+			}
+		}
+
+		if len(batch) < batchSize {
+			break
+		}
+	}
+
+	return items, nil
+}
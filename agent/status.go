@@ -0,0 +1,76 @@
+package agent
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/lamlv2305/sentinel/types"
+)
+
+// Rejection is a resource version the agent refused to persist
+type Rejection struct {
+	ProjectId  string    `json:"project_id"`
+	ResourceId string    `json:"resource_id"`
+	Group      string    `json:"group,omitempty"`
+	Version    string    `json:"version,omitempty"`
+	Error      string    `json:"error"`
+	At         time.Time `json:"at"`
+}
+
+// Status is the state of the agent
+type Status struct {
+	// Rejections lists the resources whose latest version was rejected, and
+	// which still hold their last good value
+	Rejections []Rejection `json:"rejections"`
+}
+
+// Status returns the state of the agent
+func (ra *Agent) Status() Status {
+	ra.mu.Lock()
+	defer ra.mu.Unlock()
+
+	status := Status{Rejections: make([]Rejection, 0, len(ra.rejections))}
+	for _, rejection := range ra.rejections {
+		status.Rejections = append(status.Rejections, rejection)
+	}
+	slices.SortFunc(status.Rejections, func(a, b Rejection) int {
+		return strings.Compare(a.ProjectId+"/"+a.ResourceId, b.ProjectId+"/"+b.ResourceId)
+	})
+
+	return status
+}
+
+// StatusHandler serves Status as JSON
+func (ra *Agent) StatusHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		_ = json.NewEncoder(w).Encode(ra.Status())
+	})
+}
+
+// rejected records a rejected resource version
+func (ra *Agent) rejected(resource types.Resource, cause error) {
+	ra.mu.Lock()
+	defer ra.mu.Unlock()
+
+	ra.rejections[resource.ProjectId+"/"+resource.ResourceId] = Rejection{
+		ProjectId:  resource.ProjectId,
+		ResourceId: resource.ResourceId,
+		Group:      resource.Group,
+		Version:    resource.Version,
+		Error:      cause.Error(),
+		At:         time.Now(),
+	}
+}
+
+// accepted clears the rejection of a resource once a version is persisted
+func (ra *Agent) accepted(resource types.Resource) {
+	ra.mu.Lock()
+	defer ra.mu.Unlock()
+
+	delete(ra.rejections, resource.ProjectId+"/"+resource.ResourceId)
+}
@@ -0,0 +1,88 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+
+	"github.com/lamlv2305/sentinel/types"
+)
+
+// ErrSealedUnvalidated rejects a sealed resource that must be validated but
+// cannot be decrypted, the agent having no sealer
+var ErrSealedUnvalidated = errors.New("sealed resource cannot be validated without a sealer")
+
+// Validator checks a resource, with its data decrypted, before the agent
+// persists it. A resource failing validation is not persisted, so the last
+// good value stays in place, and is rejected to the operator. Sealed resources
+// a validator applies to are rejected with ErrSealedUnvalidated when the agent
+// has no sealer to decrypt them.
+type Validator func(ctx context.Context, resource types.Resource) error
+
+type validator struct {
+	group      string // path.Match pattern, empty for any group
+	resourceId string // empty for any resource
+	validate   Validator
+}
+
+func (v validator) applies(resource types.Resource) bool {
+	if v.resourceId != "" && v.resourceId != resource.ResourceId {
+		return false
+	}
+
+	if v.group == "" {
+		return true
+	}
+
+	matched, err := path.Match(v.group, resource.Group)
+	return err == nil && matched
+}
+
+// WithValidator validates every resource
+func WithValidator(validate Validator) Option {
+	return func(o *Options) {
+		o.validators = append(o.validators, validator{validate: validate})
+	}
+}
+
+// WithGroupValidator validates the resources of the groups matching the
+// pattern, e.g. "payments/*"
+func WithGroupValidator(pattern string, validate Validator) Option {
+	return func(o *Options) {
+		o.validators = append(o.validators, validator{group: pattern, validate: validate})
+	}
+}
+
+// WithResourceValidator validates a single resource
+func WithResourceValidator(resourceId string, validate Validator) Option {
+	return func(o *Options) {
+		o.validators = append(o.validators, validator{resourceId: resourceId, validate: validate})
+	}
+}
+
+// validates reports whether any validator applies to the resource
+func (ra *Agent) validates(resource types.Resource) bool {
+	for _, v := range ra.validators {
+		if v.applies(resource) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// validate runs the validators that apply to the resource
+func (ra *Agent) validate(ctx context.Context, resource types.Resource) error {
+	for _, v := range ra.validators {
+		if !v.applies(resource) {
+			continue
+		}
+
+		if err := v.validate(ctx, resource); err != nil {
+			return fmt.Errorf("failed to validate resource: %w", err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,160 @@
+// Command sentinelctl administers an operator's API key store directly.
+//
+//	sentinelctl keys create -db keys.db -name ci -projects project-1 -roles read,secrets -ttl 720h
+//	sentinelctl keys list   -db keys.db
+//	sentinelctl keys revoke -db keys.db -id <id>
+//	sentinelctl keys rotate -db keys.db -id <id> -overlap 24h
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/lamlv2305/sentinel/operator/apikey"
+)
+
+func main() {
+	if len(os.Args) < 3 || os.Args[1] != "keys" {
+		usage()
+	}
+
+	if err := run(context.Background(), os.Args[2], os.Args[3:]); err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: sentinelctl keys <create|list|revoke|rotate> [flags]")
+	os.Exit(2)
+}
+
+func run(ctx context.Context, command string, args []string) error {
+	fs := flag.NewFlagSet("keys "+command, flag.ExitOnError)
+	db := fs.String("db", "keys.db", "path of the API key database")
+	name := fs.String("name", "", "key name (create)")
+	projects := fs.String("projects", "", "comma separated projects (create)")
+	groups := fs.String("groups", "", "comma separated group patterns (create)")
+	roles := fs.String("roles", "read", "comma separated roles: read, publish, secrets, admin (create)")
+	ttl := fs.Duration("ttl", 0, "key lifetime, 0 for no expiry (create)")
+	id := fs.String("id", "", "key id (revoke, rotate)")
+	overlap := fs.Duration("overlap", apikey.DefaultRotateOverlap, "how long the old key stays valid (rotate)")
+	_ = fs.Parse(args)
+
+	path, err := filepath.Abs(*db)
+	if err != nil {
+		return err
+	}
+
+	store, err := apikey.NewStore(path)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	switch command {
+	case "create":
+		params := apikey.CreateParams{
+			Name:     *name,
+			Projects: split(*projects),
+			Groups:   split(*groups),
+			TTL:      *ttl,
+		}
+		for _, role := range split(*roles) {
+			params.Roles = append(params.Roles, apikey.Role(role))
+		}
+
+		secret, key, err := store.Create(ctx, params)
+		if err != nil {
+			return err
+		}
+		printSecret(secret, key)
+
+	case "list":
+		keys, err := store.List(ctx)
+		if err != nil {
+			return err
+		}
+		printKeys(keys)
+
+	case "revoke":
+		if err := store.Revoke(ctx, *id); err != nil {
+			return err
+		}
+		fmt.Println("revoked", *id)
+
+	case "rotate":
+		secret, key, err := store.Rotate(ctx, *id, *overlap)
+		if err != nil {
+			return err
+		}
+		printSecret(secret, key)
+
+	default:
+		usage()
+	}
+
+	return nil
+}
+
+func printSecret(secret string, key apikey.Key) {
+	fmt.Println("id:     ", key.Id)
+	fmt.Println("secret: ", secret)
+	fmt.Println("The secret is shown only once; store it now.")
+}
+
+func printKeys(keys []apikey.Key) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\tPROJECTS\tROLES\tEXPIRES\tLAST USED\tSTATUS")
+
+	for _, key := range keys {
+		roles := make([]string, len(key.Roles))
+		for i, role := range key.Roles {
+			roles[i] = string(role)
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			key.Id, key.Name, strings.Join(key.Projects, ","), strings.Join(roles, ","),
+			formatTime(key.ExpiresAt), formatTime(key.LastUsedAt), status(key))
+	}
+
+	w.Flush()
+}
+
+func status(key apikey.Key) string {
+	switch {
+	case key.RevokedAt != nil:
+		return "revoked"
+	case key.ExpiresAt != nil && time.Now().After(*key.ExpiresAt):
+		return "expired"
+	case key.RotatedTo != "":
+		return "rotating to " + key.RotatedTo
+	default:
+		return "active"
+	}
+}
+
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return "-"
+	}
+
+	return t.Local().Format(time.RFC3339)
+}
+
+func split(s string) []string {
+	var out []string
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			out = append(out, part)
+		}
+	}
+
+	return out
+}
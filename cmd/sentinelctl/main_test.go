@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/lamlv2305/sentinel/operator/apikey"
+)
+
+func TestRun(t *testing.T) {
+	ctx := context.Background()
+	db := filepath.Join(t.TempDir(), "keys.db")
+
+	if err := run(ctx, "create", []string{"-db", db, "-name", "ci", "-projects", "billing, payroll", "-roles", "read,secrets", "-ttl", "720h"}); err != nil {
+		t.Fatal(err)
+	}
+
+	keys := func() []apikey.Key {
+		store, err := apikey.NewStore(db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer store.Close()
+
+		keys, err := store.List(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return keys
+	}
+
+	created := keys()
+	if len(created) != 1 || !slices.Equal(created[0].Projects, []string{"billing", "payroll"}) || !created[0].HasRole(apikey.RoleSecrets) {
+		t.Fatalf("keys = %+v, want the created key", created)
+	}
+	id := created[0].Id
+
+	if err := run(ctx, "list", []string{"-db", db}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := run(ctx, "rotate", []string{"-db", db, "-id", id, "-overlap", "1h"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := run(ctx, "revoke", []string{"-db", db, "-id", id}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range keys() {
+		if key.Id == id && (key.RevokedAt == nil || key.RotatedTo == "") {
+			t.Errorf("old key = %+v, want rotated and revoked", key)
+		}
+		if key.Id != id && key.RevokedAt != nil {
+			t.Errorf("replacement key %s revoked", key.Id)
+		}
+	}
+
+	if err := run(ctx, "create", []string{"-db", db, "-name", "ci"}); err == nil {
+		t.Error("create without projects succeeded")
+	}
+}
+
+func TestStatus(t *testing.T) {
+	past := time.Now().Add(-time.Hour)
+
+	tests := []struct {
+		key  apikey.Key
+		want string
+	}{
+		{key: apikey.Key{}, want: "active"},
+		{key: apikey.Key{RevokedAt: &past, ExpiresAt: &past}, want: "revoked"},
+		{key: apikey.Key{ExpiresAt: &past}, want: "expired"},
+		{key: apikey.Key{RotatedTo: "next"}, want: "rotating to next"},
+	}
+
+	for _, tt := range tests {
+		if got := status(tt.key); got != tt.want {
+			t.Errorf("status(%+v) = %q, want %q", tt.key, got, tt.want)
+		}
+	}
+}
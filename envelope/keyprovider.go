@@ -0,0 +1,180 @@
+package envelope
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lamlv2305/sentinel/keyring"
+)
+
+var (
+	ErrKeyNotFound      = keyring.ErrKeyNotFound
+	ErrInvalidProjectId = errors.New("envelope: invalid project id")
+)
+
+// KeyProvider manages the per-project key encryption keys used to wrap data
+// keys, scoped by project id.
+type KeyProvider interface {
+	keyring.Provider
+	keyring.Rotator
+}
+
+var _ KeyProvider = &FileKeyProvider{}
+
+// FileKeyProvider keeps keys as hex files under <dir>/<project>/<keyId>.key,
+// with <dir>/<project>/current naming the active key. It is meant for tests
+// and single-node setups.
+type FileKeyProvider struct {
+	dir string
+	mu  sync.Mutex
+}
+
+func NewFileKeyProvider(dir string) (*FileKeyProvider, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create key directory: %w", err)
+	}
+
+	return &FileKeyProvider{dir: dir}, nil
+}
+
+// CurrentKey implements KeyProvider. A key is generated on first use.
+func (p *FileKeyProvider) CurrentKey(ctx context.Context, projectId string) (string, []byte, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	dir, err := p.projectDir(projectId)
+	if err != nil {
+		return "", nil, err
+	}
+
+	current, err := os.ReadFile(filepath.Join(dir, "current"))
+	if errors.Is(err, os.ErrNotExist) {
+		keyId, key, err := p.generate(dir)
+		return keyId, key, err
+	}
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to read current key: %w", err)
+	}
+
+	keyId := strings.TrimSpace(string(current))
+	key, err := p.read(dir, keyId)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return keyId, key, nil
+}
+
+// Key implements KeyProvider.
+func (p *FileKeyProvider) Key(ctx context.Context, projectId string, keyId string) ([]byte, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	dir, err := p.projectDir(projectId)
+	if err != nil {
+		return nil, err
+	}
+
+	return p.read(dir, keyId)
+}
+
+// Rotate implements KeyProvider. Previous keys are kept so existing data can
+// still be opened.
+func (p *FileKeyProvider) Rotate(ctx context.Context, projectId string) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	dir, err := p.projectDir(projectId)
+	if err != nil {
+		return "", err
+	}
+
+	keyId, _, err := p.generate(dir)
+	return keyId, err
+}
+
+func (p *FileKeyProvider) projectDir(projectId string) (string, error) {
+	if projectId == "" || projectId == "." || projectId == ".." || strings.ContainsAny(projectId, `/\`) {
+		return "", ErrInvalidProjectId
+	}
+
+	dir := filepath.Join(p.dir, projectId)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", fmt.Errorf("failed to create project key directory: %w", err)
+	}
+
+	return dir, nil
+}
+
+func (p *FileKeyProvider) read(dir, keyId string) ([]byte, error) {
+	if keyId == "" || strings.ContainsAny(keyId, `/\.`) {
+		return nil, ErrKeyNotFound
+	}
+
+	encoded, err := os.ReadFile(filepath.Join(dir, keyId+".key"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrKeyNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key: %w", err)
+	}
+
+	key, err := hex.DecodeString(strings.TrimSpace(string(encoded)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode key %s: %w", keyId, err)
+	}
+
+	return key, nil
+}
+
+func (p *FileKeyProvider) generate(dir string) (string, []byte, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return "", nil, fmt.Errorf("failed to generate key: %w", err)
+	}
+
+	keyId := fmt.Sprintf("k%d", time.Now().UnixNano())
+	if err := writeFile(filepath.Join(dir, keyId+".key"), []byte(hex.EncodeToString(key))); err != nil {
+		return "", nil, fmt.Errorf("failed to write key: %w", err)
+	}
+
+	if err := writeFile(filepath.Join(dir, "current"), []byte(keyId)); err != nil {
+		return "", nil, fmt.Errorf("failed to update current key: %w", err)
+	}
+
+	return keyId, key, nil
+}
+
+// writeFile replaces the file atomically through a synced temporary file, so
+// a crash never leaves a truncated key or current file behind
+func writeFile(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,71 @@
+package envelope
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileKeyProvider(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	p, err := NewFileKeyProvider(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyId, key, err := p.CurrentKey(ctx, "billing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(key) != 32 {
+		t.Fatalf("generated a %d byte key, want 32", len(key))
+	}
+
+	// A new provider on the same directory serves the same key
+	reopened, err := NewFileKeyProvider(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, k, err := reopened.CurrentKey(ctx, "billing"); err != nil || id != keyId || !bytes.Equal(k, key) {
+		t.Fatalf("reopened current key = %s, err = %v, want %s", id, err, keyId)
+	}
+
+	rotated, err := p.Rotate(ctx, "billing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, _, err := reopened.CurrentKey(ctx, "billing"); err != nil || id != rotated {
+		t.Errorf("current key after rotation = %s, err = %v, want %s", id, err, rotated)
+	}
+	if k, err := p.Key(ctx, "billing", keyId); err != nil || !bytes.Equal(k, key) {
+		t.Errorf("retired key: err = %v", err)
+	}
+
+	entries, err := os.ReadDir(filepath.Join(dir, "billing"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if filepath.Ext(entry.Name()) == ".tmp" {
+			t.Errorf("temporary file %s left behind", entry.Name())
+		}
+	}
+
+	if _, err := p.Key(ctx, "billing", "k0"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("unknown key: error = %v, want %v", err, ErrKeyNotFound)
+	}
+	if _, err := p.Key(ctx, "billing", "../current"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("key id with a path: error = %v, want %v", err, ErrKeyNotFound)
+	}
+
+	for _, project := range []string{"", ".", "..", "a/b", `a\b`} {
+		if _, _, err := p.CurrentKey(ctx, project); !errors.Is(err, ErrInvalidProjectId) {
+			t.Errorf("project %q: error = %v, want %v", project, err, ErrInvalidProjectId)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package envelope
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+
+	"github.com/lamlv2305/sentinel/keyring"
+	"github.com/lamlv2305/sentinel/persister"
+	"github.com/lamlv2305/sentinel/types"
+)
+
+var ErrDecrypt = errors.New("envelope: failed to decrypt resource")
+
+// Sealer encrypts Resource.Data with a fresh data key per resource and wraps
+// that data key with the project key from a KeyProvider.
+type Sealer struct {
+	keys KeyProvider
+}
+
+func NewSealer(keys KeyProvider) *Sealer {
+	return &Sealer{keys: keys}
+}
+
+// Seal encrypts the resource data. Already sealed resources are returned as is.
+func (s *Sealer) Seal(ctx context.Context, r types.Resource) (types.Resource, error) {
+	if r.IsSealed() {
+		return r, nil
+	}
+
+	keyId, kek, err := s.keys.CurrentKey(ctx, r.ProjectId)
+	if err != nil {
+		return r, fmt.Errorf("failed to get project key: %w", err)
+	}
+
+	dek := make([]byte, 32)
+	if _, err := rand.Read(dek); err != nil {
+		return r, fmt.Errorf("failed to generate data key: %w", err)
+	}
+
+	wrapped, err := keyring.Seal(kek, dek, []byte(r.ProjectId))
+	if err != nil {
+		return r, fmt.Errorf("failed to wrap data key: %w", err)
+	}
+
+	sealed, err := keyring.Seal(dek, r.Data, aad(r))
+	if err != nil {
+		return r, fmt.Errorf("failed to encrypt data: %w", err)
+	}
+
+	r.Envelope = &types.Envelope{
+		KeyId:      keyId,
+		WrappedKey: wrapped,
+		Nonce:      sealed[:keyring.NonceSize],
+	}
+	r.Data = sealed[keyring.NonceSize:]
+
+	return r, nil
+}
+
+// Open decrypts the resource data. Plain resources are returned as is.
+func (s *Sealer) Open(ctx context.Context, r types.Resource) (types.Resource, error) {
+	if !r.IsSealed() {
+		return r, nil
+	}
+
+	kek, err := s.keys.Key(ctx, r.ProjectId, r.Envelope.KeyId)
+	if err != nil {
+		return r, fmt.Errorf("failed to get project key %s: %w", r.Envelope.KeyId, err)
+	}
+
+	dek, err := keyring.Open(kek, r.Envelope.WrappedKey, []byte(r.ProjectId))
+	if err != nil {
+		return r, ErrDecrypt
+	}
+
+	data, err := keyring.Open(dek, append(append([]byte{}, r.Envelope.Nonce...), r.Data...), aad(r))
+	if err != nil {
+		return r, ErrDecrypt
+	}
+
+	r.Data = data
+	r.Envelope = nil
+
+	return r, nil
+}
+
+// Rotate makes a new project key current. Resources sealed with the previous
+// key keep opening until they are resealed.
+func (s *Sealer) Rotate(ctx context.Context, projectId string) (string, error) {
+	return s.keys.Rotate(ctx, projectId)
+}
+
+// Reseal re-encrypts a sealed resource under the current project key. It
+// reports whether the resource changed.
+func (s *Sealer) Reseal(ctx context.Context, r types.Resource) (types.Resource, bool, error) {
+	if !r.IsSealed() {
+		return r, false, nil
+	}
+
+	keyId, _, err := s.keys.CurrentKey(ctx, r.ProjectId)
+	if err != nil {
+		return r, false, fmt.Errorf("failed to get project key: %w", err)
+	}
+
+	if r.Envelope.KeyId == keyId {
+		return r, false, nil
+	}
+
+	opened, err := s.Open(ctx, r)
+	if err != nil {
+		return r, false, err
+	}
+
+	sealed, err := s.Seal(ctx, opened)
+	if err != nil {
+		return r, false, err
+	}
+
+	return sealed, true, nil
+}
+
+// ResealAll walks the persister and reseals every resource that is not
+// encrypted under the current key of its project. It returns how many
+// resources were rewritten.
+func (s *Sealer) ResealAll(ctx context.Context, p persister.Persister[types.Resource], batchSize int) (int, error) {
+	if batchSize <= 0 {
+		batchSize = 100
+	}
+
+	resealed := 0
+	for offset := 0; ; offset += batchSize {
+		items, err := p.List(ctx, offset, batchSize)
+		if err != nil {
+			return resealed, fmt.Errorf("failed to list resources: %w", err)
+		}
+
+		for _, item := range items {
+			updated, changed, err := s.Reseal(ctx, item)
+			if err != nil {
+				return resealed, fmt.Errorf("failed to reseal resource %s: %w", item.Id(), err)
+			}
+			if !changed {
+				continue
+			}
+
+			if err := p.Save(ctx, updated); err != nil {
+				return resealed, fmt.Errorf("failed to save resource %s: %w", item.Id(), err)
+			}
+			resealed++
+		}
+
+		if len(items) < batchSize {
+			return resealed, nil
+		}
+	}
+}
+
+// aad binds the ciphertext to the resource it belongs to.
+func aad(r types.Resource) []byte {
+	return []byte(r.ProjectId + "/" + r.ResourceId)
+}
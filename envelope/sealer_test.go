@@ -0,0 +1,217 @@
+package envelope
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/lamlv2305/sentinel/persister"
+	"github.com/lamlv2305/sentinel/types"
+)
+
+func newTestSealer(t *testing.T) *Sealer {
+	t.Helper()
+
+	keys, err := NewFileKeyProvider(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return NewSealer(keys)
+}
+
+func TestSealOpen(t *testing.T) {
+	ctx := context.Background()
+	s := newTestSealer(t)
+	plain := types.Resource{ProjectId: "billing", ResourceId: "db", Data: []byte("hunter2")}
+
+	sealed, err := s.Seal(ctx, plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sealed.IsSealed() || bytes.Contains(sealed.Data, plain.Data) {
+		t.Fatalf("sealed = %+v, want encrypted data", sealed)
+	}
+
+	again, err := s.Seal(ctx, sealed)
+	if err != nil || !bytes.Equal(again.Data, sealed.Data) {
+		t.Errorf("sealing a sealed resource changed it: %v", err)
+	}
+
+	opened, err := s.Open(ctx, sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opened.IsSealed() || !bytes.Equal(opened.Data, plain.Data) {
+		t.Errorf("opened = %+v, want %+v", opened, plain)
+	}
+
+	if same, err := s.Open(ctx, plain); err != nil || !bytes.Equal(same.Data, plain.Data) {
+		t.Errorf("opening a plain resource changed it: %v", err)
+	}
+}
+
+func TestOpenBindsProjectAndResource(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	keys, err := NewFileKeyProvider(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewSealer(keys)
+
+	sealed, err := s.Seal(ctx, types.Resource{ProjectId: "billing", ResourceId: "db", Data: []byte("hunter2")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Give payroll the very same key, so only the additional data tells the
+	// projects apart
+	keyFile := sealed.Envelope.KeyId + ".key"
+	shared, err := os.ReadFile(filepath.Join(dir, "billing", keyFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "payroll"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "payroll", keyFile), shared, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		mutate func(r *types.Resource)
+		err    error
+	}{
+		{name: "other resource", mutate: func(r *types.Resource) { r.ResourceId = "cache" }, err: ErrDecrypt},
+		{name: "other project", mutate: func(r *types.Resource) { r.ProjectId = "payroll" }, err: ErrDecrypt},
+		{name: "project without the key", mutate: func(r *types.Resource) { r.ProjectId = "ops" }, err: ErrKeyNotFound},
+		{name: "tampered data", mutate: func(r *types.Resource) { r.Data = append([]byte{r.Data[0] ^ 1}, r.Data[1:]...) }, err: ErrDecrypt},
+		{name: "tampered wrapped key", mutate: func(r *types.Resource) {
+			wrapped := bytes.Clone(r.Envelope.WrappedKey)
+			wrapped[len(wrapped)-1] ^= 1
+			r.Envelope = &types.Envelope{KeyId: r.Envelope.KeyId, WrappedKey: wrapped, Nonce: r.Envelope.Nonce}
+		}, err: ErrDecrypt},
+		{name: "unknown key", mutate: func(r *types.Resource) {
+			r.Envelope = &types.Envelope{KeyId: "k0", WrappedKey: r.Envelope.WrappedKey, Nonce: r.Envelope.Nonce}
+		}, err: ErrKeyNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := sealed
+			tt.mutate(&r)
+			if _, err := s.Open(ctx, r); !errors.Is(err, tt.err) {
+				t.Errorf("error = %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestRotateAndReseal(t *testing.T) {
+	ctx := context.Background()
+	s := newTestSealer(t)
+	plain := types.Resource{ProjectId: "billing", ResourceId: "db", Data: []byte("hunter2")}
+
+	old, err := s.Seal(ctx, plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, changed, err := s.Reseal(ctx, old); err != nil || changed {
+		t.Fatalf("Reseal under the current key: changed = %v, err = %v", changed, err)
+	}
+
+	keyId, err := s.Rotate(ctx, "billing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keyId == old.Envelope.KeyId {
+		t.Fatalf("Rotate kept key %s", keyId)
+	}
+
+	// Resources sealed with the previous key still open
+	if opened, err := s.Open(ctx, old); err != nil || !bytes.Equal(opened.Data, plain.Data) {
+		t.Fatalf("Open after rotation: %v", err)
+	}
+
+	resealed, changed, err := s.Reseal(ctx, old)
+	if err != nil || !changed {
+		t.Fatalf("Reseal after rotation: changed = %v, err = %v", changed, err)
+	}
+	if resealed.Envelope.KeyId != keyId {
+		t.Errorf("resealed with %s, want %s", resealed.Envelope.KeyId, keyId)
+	}
+	if opened, err := s.Open(ctx, resealed); err != nil || !bytes.Equal(opened.Data, plain.Data) {
+		t.Errorf("Open resealed: %v", err)
+	}
+
+	if _, changed, err := s.Reseal(ctx, plain); err != nil || changed {
+		t.Errorf("Reseal of a plain resource: changed = %v, err = %v", changed, err)
+	}
+}
+
+func TestResealAll(t *testing.T) {
+	ctx := context.Background()
+	s := newTestSealer(t)
+
+	p, err := persister.NewSQLitePersister[types.Resource](filepath.Join(t.TempDir(), "db.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	resources := []types.Resource{
+		{ProjectId: "billing", ResourceId: "a", Data: []byte("1")},
+		{ProjectId: "billing", ResourceId: "b", Data: []byte("2")},
+		{ProjectId: "payroll", ResourceId: "c", Data: []byte("3")},
+	}
+	for _, r := range resources {
+		sealed, err := s.Seal(ctx, r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := p.Save(ctx, sealed); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := p.Save(ctx, types.Resource{ProjectId: "billing", ResourceId: "plain", Data: []byte("4")}); err != nil {
+		t.Fatal(err)
+	}
+
+	keyId, err := s.Rotate(ctx, "billing")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resealed, err := s.ResealAll(ctx, p, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resealed != 2 {
+		t.Errorf("resealed %d resources, want the 2 of billing", resealed)
+	}
+
+	for _, r := range resources {
+		stored, err := p.Get(ctx, r.ResourceId)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.ProjectId == "billing" && stored.Envelope.KeyId != keyId {
+			t.Errorf("%s sealed with %s, want %s", r.ResourceId, stored.Envelope.KeyId, keyId)
+		}
+
+		opened, err := s.Open(ctx, stored)
+		if err != nil || !bytes.Equal(opened.Data, r.Data) {
+			t.Errorf("Open %s: data = %q, err = %v", r.ResourceId, opened.Data, err)
+		}
+	}
+
+	if again, err := s.ResealAll(ctx, p, 2); err != nil || again != 0 {
+		t.Errorf("second ResealAll: resealed = %d, err = %v", again, err)
+	}
+}
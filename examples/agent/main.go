@@ -28,13 +28,17 @@ func main() {
 
 	listener := agent.New(
 		agent.WithPersister(persister),
-		agent.WithAdapter(agent.NewSSEAdapter("http://localhost:8080/sse?project=project-1&apikey=apikey-1111111111")),
+		agent.WithAdapter(agent.NewSSEAdapter(
+			"http://localhost:8080/sse",
+			agent.WithProject("project-1"),
+			agent.WithAPIKey("apikey-1111111111"),
+		)),
 		agent.WithSubscriber(subscriber),
 	)
 
 	go func() {
 		for resource := range subscriber {
-			jsonMsg, _ := json.Marshal(resource)
+			jsonMsg, _ := json.Marshal(resource.Redacted())
 			slog.Info("Received update", "resource", jsonMsg)
 			// Process the resource update as needed
 		}
@@ -2,6 +2,8 @@ package main
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"log/slog"
 	"net/http"
@@ -40,13 +42,17 @@ func main() {
 	sse := operator.NewSSE(
 		mux,
 		"/sse",
-		operator.WithSSECredentialVerifier(func(ctx context.Context, apikey string, project string) error {
+		operator.WithSSECredentialVerifier(func(ctx context.Context, apikey string, project string) (*operator.Principal, error) {
 			// Implement your credential verification logic here
 			if apikey == "" || project == "" {
-				return errors.New("invalid credentials")
+				return nil, errors.New("invalid credentials")
 			}
-			return nil
+			// Never log the raw credential; identify it by a hash prefix instead
+			sum := sha256.Sum256([]byte(apikey))
+			keyId := hex.EncodeToString(sum[:])[:12]
+			return &operator.Principal{Subject: "key-" + keyId, KeyId: keyId}, nil
 		}),
+		operator.WithSSETicketEndpoint("/sse/ticket", 30*time.Second),
 	)
 	go sse.Run(context.Background())
 
@@ -59,7 +65,7 @@ func main() {
 
 			slog.Debug("Broadcasting event", "data", encoded)
 
-			err := sse.Broadcast(context.Background(), types.ChangedEvent{
+			_, err := sse.Broadcast(context.Background(), types.ChangedEvent{
 				Action:    types.ActionTypeUpdate,
 				Timestamp: time.Now(),
 				Resource: types.Resource{
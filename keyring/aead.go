@@ -0,0 +1,58 @@
+package keyring
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+)
+
+var ErrOpen = errors.New("keyring: failed to open sealed data")
+
+// NonceSize is the standard AES-GCM nonce size.
+const NonceSize = 12
+
+// Seal encrypts plaintext with AES-GCM and returns nonce || ciphertext.
+func Seal(key, plaintext, additional []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, NonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	return gcm.Seal(nonce, nonce, plaintext, additional), nil
+}
+
+// Open decrypts nonce || ciphertext as returned by Seal. It fails with ErrOpen
+// on a wrong key, a wrong additional data or tampered data.
+func Open(key, sealed, additional []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(sealed) < NonceSize {
+		return nil, ErrOpen
+	}
+
+	plaintext, err := gcm.Open(nil, sealed[:NonceSize], sealed[NonceSize:], additional)
+	if err != nil {
+		return nil, ErrOpen
+	}
+
+	return plaintext, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid encryption key: %w", err)
+	}
+
+	return cipher.NewGCM(block)
+}
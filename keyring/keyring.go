@@ -0,0 +1,84 @@
+// Package keyring holds the key providers and AES-GCM helpers shared by the
+// envelope sealer and the persister's encryption at rest.
+package keyring
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+var ErrKeyNotFound = errors.New("keyring: key not found")
+
+// Provider supplies AES keys by scope, e.g. a project id. Providers that keep
+// a single set of keys may ignore the scope.
+type Provider interface {
+	// CurrentKey returns the key new data of the scope is encrypted with.
+	CurrentKey(ctx context.Context, scope string) (keyId string, key []byte, err error)
+
+	// Key returns a key of the scope by id, including retired ones still in
+	// use by older data.
+	Key(ctx context.Context, scope string, keyId string) ([]byte, error)
+}
+
+// Rotator is implemented by providers able to generate a new key on their own.
+type Rotator interface {
+	// Rotate generates a new key for the scope and makes it current.
+	Rotate(ctx context.Context, scope string) (keyId string, err error)
+}
+
+var _ Provider = &StaticProvider{}
+
+// StaticProvider serves an in-memory set of keys for every scope; the first
+// one given is current until AddKey is called.
+type StaticProvider struct {
+	mu      sync.RWMutex
+	current string
+	keys    map[string][]byte
+}
+
+func NewStaticProvider(keyId string, key []byte) *StaticProvider {
+	return &StaticProvider{
+		current: keyId,
+		keys:    map[string][]byte{keyId: key},
+	}
+}
+
+// WithRetiredKey keeps an older key available for decryption.
+func (p *StaticProvider) WithRetiredKey(keyId string, key []byte) *StaticProvider {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.keys[keyId] = key
+	return p
+}
+
+// AddKey makes a new key current; previous keys stay available for decryption.
+func (p *StaticProvider) AddKey(keyId string, key []byte) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.keys[keyId] = key
+	p.current = keyId
+}
+
+// CurrentKey implements Provider.
+func (p *StaticProvider) CurrentKey(ctx context.Context, scope string) (string, []byte, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return p.current, p.keys[p.current], nil
+}
+
+// Key implements Provider.
+func (p *StaticProvider) Key(ctx context.Context, scope string, keyId string) ([]byte, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	key, ok := p.keys[keyId]
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+
+	return key, nil
+}
@@ -0,0 +1,413 @@
+package operator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/goccy/go-json"
+	"github.com/lamlv2305/sentinel/types"
+)
+
+var (
+	ErrAcksNotTracked    = errors.New("acks are not tracked")
+	ErrQuorumUnreachable = errors.New("quorum unreachable")
+	ErrInvalidQuorum     = errors.New("quorum must be within (0, 1]")
+)
+
+// defaultAckTrackerSize is how many resource versions are tracked at once
+const defaultAckTrackerSize = 1024
+
+// maxAckBody bounds the size of an ack
+const maxAckBody = 64 << 10
+
+// RolloutStatus tells which agents applied a resource version. Only agents
+// declaring the ack capability are tracked; others are counted as untracked.
+type RolloutStatus struct {
+	ProjectId   string            `json:"project_id"`
+	ResourceId  string            `json:"resource_id"`
+	Version     string            `json:"version"`
+	PublishedAt time.Time         `json:"published_at"`
+	Delivered   int               `json:"delivered"` // deliveries of the version to clients
+	Untracked   int               `json:"untracked"` // of them, clients that do not ack
+	Applied     []string          `json:"applied"`
+	Rejected    map[string]string `json:"rejected,omitempty"` // connection id to error
+	Failed      map[string]string `json:"failed,omitempty"`   // connection id to error
+	Pending     []string          `json:"pending"`
+}
+
+// WithSSEAckEndpoint serves HandleAck on the given endpoint and tracks the
+// rollout of every broadcast version, see RolloutStatus
+func WithSSEAckEndpoint(endpoint string) WithSSE {
+	return func(s *SSE) {
+		s.ackEndpoint = endpoint
+		s.acks = newAckTracker(defaultAckTrackerSize)
+	}
+}
+
+type versionKey struct {
+	project  string
+	resource string
+	version  string
+}
+
+// recipient is an ack-capable connection a version was delivered to
+type recipient struct {
+	principal *Principal
+	identity  string // see Client.identity
+}
+
+type rollout struct {
+	publishedAt time.Time
+	delivered   int
+	untracked   int
+	pending     map[string]struct{}
+	recipients  map[string]recipient // connections the version was delivered to
+	acks        map[string]types.Ack // by connection id
+	early       map[string]types.Ack // acks of live connections not known as recipients yet
+	updated     chan struct{}        // closed and replaced on every ack
+}
+
+// ackTracker keeps the rollout of the latest versions, forgetting the oldest
+// ones beyond its size
+type ackTracker struct {
+	size int
+
+	mu       sync.Mutex
+	rollouts map[versionKey]*rollout
+	order    []versionKey
+	latest   map[[2]string]string // project and resource to their latest version
+}
+
+func newAckTracker(size int) *ackTracker {
+	return &ackTracker{
+		size:     size,
+		rollouts: make(map[versionKey]*rollout),
+		latest:   make(map[[2]string]string),
+	}
+}
+
+// publishing starts tracking a version before it is delivered, so acks
+// arriving before delivered is called are kept. A version broadcast again,
+// e.g. by a staged rollout, keeps what was tracked so far.
+func (t *ackTracker) publishing(resource types.Resource) {
+	key := versionKey{resource.ProjectId, resource.ResourceId, resource.Version}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.latest[[2]string{key.project, key.resource}] = key.version
+	if _, ok := t.rollouts[key]; ok {
+		return
+	}
+
+	t.order = append(t.order, key)
+	t.rollouts[key] = &rollout{
+		publishedAt: time.Now(),
+		pending:     make(map[string]struct{}),
+		recipients:  make(map[string]recipient),
+		acks:        make(map[string]types.Ack),
+		early:       make(map[string]types.Ack),
+		updated:     make(chan struct{}),
+	}
+
+	for len(t.order) > t.size {
+		oldest := t.order[0]
+		t.order = t.order[1:]
+		delete(t.rollouts, oldest)
+		if t.latest[[2]string{oldest.project, oldest.resource}] == oldest.version {
+			delete(t.latest, [2]string{oldest.project, oldest.resource})
+		}
+	}
+}
+
+// delivered records the clients the version was queued to
+func (t *ackTracker) delivered(resource types.Resource, recipients []*Client) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	r, ok := t.rollouts[versionKey{resource.ProjectId, resource.ResourceId, resource.Version}]
+	if !ok {
+		return
+	}
+
+	r.delivered += len(recipients)
+	for _, client := range recipients {
+		if !client.Metadata.HasCapability(types.CapabilityAck) {
+			r.untracked++
+			continue
+		}
+		r.recipients[client.Id] = recipient{principal: client.Principal, identity: client.identity()}
+		if ack, ok := r.early[client.Id]; ok {
+			r.acks[client.Id] = ack
+			delete(r.early, client.Id)
+		}
+		if _, acked := r.acks[client.Id]; !acked {
+			r.pending[client.Id] = struct{}{}
+		}
+	}
+	r.notify()
+}
+
+// reconnected returns the recipient an agent that reconnected was, preferring
+// one still pending
+func (r *rollout) reconnected(owner *Principal, identity string) (string, bool) {
+	found, ok := "", false
+	for connectionId, recipient := range r.recipients {
+		if recipient.identity != identity || !sameOwner(recipient.principal, owner) {
+			continue
+		}
+
+		if _, pending := r.pending[connectionId]; pending {
+			return connectionId, true
+		}
+		found, ok = connectionId, true
+	}
+
+	return found, ok
+}
+
+func (r *rollout) notify() {
+	close(r.updated)
+	r.updated = make(chan struct{})
+}
+
+// record applies an ack of a connection the version was delivered to, and
+// ignores acks of untracked versions. An agent that reconnected acks from a
+// new connection, given as its owner and identity, which is recorded as the
+// recipient with the same owner and identity. Acks of other connections are
+// held until delivered names them, in case they beat it, and are not counted
+// otherwise.
+func (t *ackTracker) record(ack types.Ack, owner *Principal, identity string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	r, ok := t.rollouts[versionKey{ack.ProjectId, ack.ResourceId, ack.Version}]
+	if !ok {
+		return false
+	}
+
+	if _, ok := r.recipients[ack.ConnectionId]; !ok {
+		connectionId, ok := r.reconnected(owner, identity)
+		if !ok {
+			r.early[ack.ConnectionId] = ack
+			return false
+		}
+		ack.ConnectionId = connectionId
+	}
+
+	delete(r.pending, ack.ConnectionId)
+	r.acks[ack.ConnectionId] = ack
+	r.notify()
+	return true
+}
+
+// recipient returns the principal of a connection the version was delivered
+// to, reporting false when it was not delivered to it
+func (t *ackTracker) recipient(ack types.Ack) (*Principal, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	r, ok := t.rollouts[versionKey{ack.ProjectId, ack.ResourceId, ack.Version}]
+	if !ok {
+		return nil, false
+	}
+
+	recipient, ok := r.recipients[ack.ConnectionId]
+	return recipient.principal, ok
+}
+
+// status returns the rollout of a version, or of the latest one when version
+// is empty
+func (t *ackTracker) status(project, resource, version string) (RolloutStatus, bool) {
+	status, _, ok := t.watch(project, resource, version)
+	return status, ok
+}
+
+// watch is status, also returning a channel closed on the next change
+func (t *ackTracker) watch(project, resource, version string) (RolloutStatus, <-chan struct{}, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if version == "" {
+		version = t.latest[[2]string{project, resource}]
+	}
+
+	r, ok := t.rollouts[versionKey{project, resource, version}]
+	if !ok {
+		return RolloutStatus{}, nil, false
+	}
+
+	status := RolloutStatus{
+		ProjectId:   project,
+		ResourceId:  resource,
+		Version:     version,
+		PublishedAt: r.publishedAt,
+		Delivered:   r.delivered,
+		Untracked:   r.untracked,
+		Applied:     []string{},
+		Pending:     make([]string, 0, len(r.pending)),
+	}
+
+	for id, ack := range r.acks {
+		switch ack.Status {
+		case types.AckApplied:
+			status.Applied = append(status.Applied, id)
+		case types.AckRejected:
+			if status.Rejected == nil {
+				status.Rejected = make(map[string]string)
+			}
+			status.Rejected[id] = ack.Error
+		default:
+			if status.Failed == nil {
+				status.Failed = make(map[string]string)
+			}
+			status.Failed[id] = ack.Error
+		}
+	}
+	for id := range r.pending {
+		status.Pending = append(status.Pending, id)
+	}
+	slices.Sort(status.Applied)
+	slices.Sort(status.Pending)
+
+	return status, r.updated, true
+}
+
+// RolloutStatus returns which agents applied a version of a resource, or its
+// latest version when version is empty. It reports false when acks are not
+// tracked, see WithSSEAckEndpoint, or the version is no longer tracked.
+func (s *SSE) RolloutStatus(projectId, resourceId, version string) (RolloutStatus, bool) {
+	if s.acks == nil {
+		return RolloutStatus{}, false
+	}
+
+	return s.acks.status(projectId, resourceId, version)
+}
+
+// PublishAndWait broadcasts the event, then waits until a quorum, the
+// fraction in (0, 1] of the agents it was delivered to that ack, applied it.
+// It fails with ErrQuorumUnreachable once too many of them rejected or failed
+// it, or right away when it reached no agent that acks, and with the
+// context's error when it is done first. Acks must be tracked, see
+// WithSSEAckEndpoint.
+func (s *SSE) PublishAndWait(ctx context.Context, event types.ChangedEvent, quorum float64) (DeliveryReport, error) {
+	if s.acks == nil {
+		return DeliveryReport{}, ErrAcksNotTracked
+	}
+
+	if !(quorum > 0 && quorum <= 1) {
+		return DeliveryReport{}, ErrInvalidQuorum
+	}
+
+	report, err := s.Broadcast(ctx, event)
+	if err != nil {
+		return report, err
+	}
+
+	for {
+		status, updated, ok := s.acks.watch(event.Resource.ProjectId, event.Resource.ResourceId, report.Version)
+		if !ok {
+			return report, fmt.Errorf("failed to wait for acks: version %s no longer tracked", report.Version)
+		}
+
+		tracked := len(status.Applied) + len(status.Rejected) + len(status.Failed) + len(status.Pending)
+		if tracked == 0 {
+			return report, fmt.Errorf("%w: no agent that acks received version %s", ErrQuorumUnreachable, report.Version)
+		}
+
+		needed := int(math.Ceil(quorum * float64(tracked)))
+		if len(status.Applied) >= needed {
+			return report, nil
+		}
+		if len(status.Applied)+len(status.Pending) < needed {
+			return report, fmt.Errorf("%w: %d of %d agents applied version %s, %d needed",
+				ErrQuorumUnreachable, len(status.Applied), tracked, report.Version, needed)
+		}
+
+		select {
+		case <-ctx.Done():
+			return report, fmt.Errorf("failed to reach quorum: %w", context.Cause(ctx))
+		case <-updated:
+		}
+	}
+}
+
+// HandleAck accepts a types.Ack as JSON in a POST request. The agent
+// authenticates like a subscriber of the ack's project, and the ack must name
+// one of its own connections: a live one, or one the version was delivered to.
+// Its status must be applied, rejected or failed. Only acks of connections the
+// version was delivered to, or of agents that reconnected since, are counted.
+func (s *SSE) HandleAck(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	auth, status := s.authenticate(r)
+	if status != http.StatusOK {
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+
+	var ack types.Ack
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAckBody)).Decode(&ack); err != nil {
+		http.Error(w, "Invalid ack", http.StatusBadRequest)
+		return
+	}
+
+	if ack.ConnectionId == "" || ack.ResourceId == "" || ack.Version == "" || !ack.Status.Valid() || !slices.Contains(auth.projects, ack.ProjectId) {
+		http.Error(w, "Invalid ack", http.StatusBadRequest)
+		return
+	}
+
+	if !s.Authorize(auth.principal, ActionSubscribe, ack.ProjectId, AnyGroup) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	identity, ok := s.ackConnection(auth.principal, ack)
+	if !ok {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	if s.acks == nil || !s.acks.record(ack, auth.principal, identity) {
+		slog.Debug("Ignored ack of untracked version or connection", "ack", ack)
+	} else if ack.Status != types.AckApplied {
+		slog.Warn("Agent did not apply resource version", "ack", ack)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ackConnection reports whether the connection the ack names belongs to the
+// principal, returning the identity of the connection when it is live. The
+// connection has to be live or one the acked version was delivered to, so
+// acks cannot be made up under unknown connection ids.
+func (s *SSE) ackConnection(principal *Principal, ack types.Ack) (string, bool) {
+	if clients := s.hub.find(func(c *Client) bool { return c.Id == ack.ConnectionId }); len(clients) > 0 {
+		return clients[0].identity(), sameOwner(clients[0].Principal, principal)
+	}
+
+	if s.acks == nil {
+		return "", false
+	}
+
+	owner, ok := s.acks.recipient(ack)
+	return "", ok && sameOwner(owner, principal)
+}
+
+func sameOwner(owner, principal *Principal) bool {
+	if owner == nil || principal == nil {
+		return owner == principal
+	}
+
+	return owner.Subject == principal.Subject && owner.KeyId == principal.KeyId
+}
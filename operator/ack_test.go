@@ -0,0 +1,248 @@
+package operator
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/goccy/go-json"
+	"github.com/lamlv2305/sentinel/types"
+)
+
+func TestHandleAckOwnership(t *testing.T) {
+	s := NewSSE(http.NewServeMux(), "/sse",
+		WithSSEAckEndpoint("/ack"),
+		WithSSECredentialVerifier(func(ctx context.Context, apikey, project string) (*Principal, error) {
+			if apikey != "agent-1" && apikey != "agent-2" {
+				return nil, errors.New("unknown key")
+			}
+			return &Principal{Subject: apikey, KeyId: apikey}, nil
+		}),
+	)
+
+	client := NewClient("c1", "billing")
+	client.Principal = &Principal{Subject: "agent-1", KeyId: "agent-1"}
+	client.Metadata.Capabilities = []string{types.CapabilityAck}
+	s.hub.add(client)
+
+	report, err := s.Broadcast(context.Background(), types.ChangedEvent{Resource: types.Resource{ProjectId: "billing", ResourceId: "config"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ack := func(apikey, connectionId string) int {
+		body, _ := json.Marshal(types.Ack{
+			ConnectionId: connectionId,
+			ProjectId:    "billing",
+			ResourceId:   "config",
+			Version:      report.Version,
+			Status:       types.AckApplied,
+		})
+
+		r := httptest.NewRequest(http.MethodPost, "/ack", bytes.NewReader(body))
+		r.Header.Set(types.HeaderAPIKey, apikey)
+		r.Header.Set(types.HeaderProject, "billing")
+		w := httptest.NewRecorder()
+		s.HandleAck(w, r)
+		return w.Code
+	}
+
+	if got := ack("agent-1", "forged"); got != http.StatusForbidden {
+		t.Errorf("ack under a forged connection id: status = %d, want %d", got, http.StatusForbidden)
+	}
+	if got := ack("agent-2", "c1"); got != http.StatusForbidden {
+		t.Errorf("ack for another principal's live connection: status = %d, want %d", got, http.StatusForbidden)
+	}
+
+	// Acks may arrive after the connection closed, from its owner only
+	s.hub.remove(client)
+	if got := ack("agent-2", "c1"); got != http.StatusForbidden {
+		t.Errorf("ack for another principal's recipient: status = %d, want %d", got, http.StatusForbidden)
+	}
+	if got := ack("agent-1", "c1"); got != http.StatusNoContent {
+		t.Errorf("ack of the recipient: status = %d, want %d", got, http.StatusNoContent)
+	}
+
+	status, ok := s.RolloutStatus("billing", "config", "")
+	if !ok || !slices.Equal(status.Applied, []string{"c1"}) {
+		t.Errorf("rollout = %+v, want c1 applied only", status)
+	}
+}
+
+func TestPublishAndWait(t *testing.T) {
+	newSSE := func(clients int) *SSE {
+		s := NewSSE(http.NewServeMux(), "/sse", WithSSEAckEndpoint("/ack"))
+		for i := range clients {
+			client := NewClient(fmt.Sprintf("c%d", i), "billing")
+			client.Principal = &Principal{Subject: "agent"}
+			client.Metadata.Capabilities = []string{types.CapabilityAck}
+			s.hub.add(client)
+		}
+		return s
+	}
+
+	event := types.ChangedEvent{Resource: types.Resource{ProjectId: "billing", ResourceId: "config", Version: "v1"}}
+
+	// publish runs PublishAndWait, answering with the given acks once the
+	// version is delivered
+	publish := func(s *SSE, ctx context.Context, quorum float64, acks ...types.AckStatus) error {
+		done := make(chan error, 1)
+		go func() {
+			_, err := s.PublishAndWait(ctx, event, quorum)
+			done <- err
+		}()
+
+		for len(acks) > 0 {
+			status, ok := s.RolloutStatus("billing", "config", "v1")
+			if !ok || status.Delivered == 0 {
+				time.Sleep(time.Millisecond)
+				continue
+			}
+			for i, ack := range acks {
+				s.acks.record(types.Ack{ConnectionId: fmt.Sprintf("c%d", i), ProjectId: "billing", ResourceId: "config", Version: "v1", Status: ack}, nil, "")
+			}
+			break
+		}
+
+		return <-done
+	}
+
+	t.Run("quorum reached", func(t *testing.T) {
+		s := newSSE(4)
+		if err := publish(s, context.Background(), 0.75, types.AckApplied, types.AckApplied, types.AckFailed, types.AckApplied); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("quorum unreachable", func(t *testing.T) {
+		s := newSSE(4)
+		err := publish(s, context.Background(), 0.75, types.AckApplied, types.AckRejected, types.AckFailed)
+		if !errors.Is(err, ErrQuorumUnreachable) {
+			t.Fatalf("error = %v, want %v", err, ErrQuorumUnreachable)
+		}
+	})
+
+	t.Run("context done", func(t *testing.T) {
+		s := newSSE(4)
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+
+		err := publish(s, ctx, 1, types.AckApplied)
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("error = %v, want %v", err, context.DeadlineExceeded)
+		}
+	})
+
+	t.Run("no agent acks", func(t *testing.T) {
+		s := newSSE(0)
+		if err := publish(s, context.Background(), 0.5); !errors.Is(err, ErrQuorumUnreachable) {
+			t.Fatalf("error = %v, want %v", err, ErrQuorumUnreachable)
+		}
+	})
+
+	for _, quorum := range []float64{0, -0.5, 1.5, math.NaN()} {
+		if _, err := newSSE(1).PublishAndWait(context.Background(), event, quorum); !errors.Is(err, ErrInvalidQuorum) {
+			t.Errorf("quorum %v: error = %v, want %v", quorum, err, ErrInvalidQuorum)
+		}
+	}
+
+	s := NewSSE(http.NewServeMux(), "/sse")
+	if _, err := s.PublishAndWait(context.Background(), event, 1); !errors.Is(err, ErrAcksNotTracked) {
+		t.Errorf("without acks: error = %v, want %v", err, ErrAcksNotTracked)
+	}
+}
+
+func TestHandleAckRecipients(t *testing.T) {
+	s := NewSSE(http.NewServeMux(), "/sse",
+		WithSSEAckEndpoint("/ack"),
+		WithSSECredentialVerifier(func(ctx context.Context, apikey, project string) (*Principal, error) {
+			return &Principal{Subject: apikey, KeyId: apikey}, nil
+		}),
+	)
+
+	newClient := func(id, hostname string) *Client {
+		client := NewClient(id, "billing")
+		client.Principal = &Principal{Subject: "agent-1", KeyId: "agent-1"}
+		client.Metadata.Hostname = hostname
+		client.Metadata.Capabilities = []string{types.CapabilityAck}
+		s.hub.add(client)
+		return client
+	}
+
+	first := newClient("c1", "host-1")
+	report, err := s.Broadcast(context.Background(), types.ChangedEvent{Resource: types.Resource{ProjectId: "billing", ResourceId: "config"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ack := func(connectionId string, status types.AckStatus) int {
+		body, _ := json.Marshal(types.Ack{
+			ConnectionId: connectionId,
+			ProjectId:    "billing",
+			ResourceId:   "config",
+			Version:      report.Version,
+			Status:       status,
+		})
+
+		r := httptest.NewRequest(http.MethodPost, "/ack", bytes.NewReader(body))
+		r.Header.Set(types.HeaderAPIKey, "agent-1")
+		r.Header.Set(types.HeaderProject, "billing")
+		w := httptest.NewRecorder()
+		s.HandleAck(w, r)
+		return w.Code
+	}
+
+	for _, status := range []types.AckStatus{"", "done", "APPLIED"} {
+		if got := ack("c1", status); got != http.StatusBadRequest {
+			t.Errorf("ack with status %q: status = %d, want %d", status, got, http.StatusBadRequest)
+		}
+	}
+
+	// A connection of the same owner the version was never delivered to
+	newClient("c2", "host-2")
+	if got := ack("c2", types.AckApplied); got != http.StatusNoContent {
+		t.Fatalf("ack of a live connection: status = %d", got)
+	}
+
+	// The same agent reconnected and acks from its new connection
+	s.hub.remove(first)
+	newClient("c3", "host-1")
+	if got := ack("c3", types.AckApplied); got != http.StatusNoContent {
+		t.Fatalf("ack of a reconnected agent: status = %d", got)
+	}
+
+	status, ok := s.RolloutStatus("billing", "config", report.Version)
+	if !ok || !slices.Equal(status.Applied, []string{"c1"}) || len(status.Pending) != 0 {
+		t.Errorf("rollout = %+v, want the reconnected agent applied as c1 only", status)
+	}
+}
+
+func TestAckBeforeDelivered(t *testing.T) {
+	tracker := newAckTracker(10)
+	resource := types.Resource{ProjectId: "billing", ResourceId: "config", Version: "v1"}
+	tracker.publishing(resource)
+
+	client := NewClient("c1", "billing")
+	client.Metadata.Capabilities = []string{types.CapabilityAck}
+
+	ack := types.Ack{ConnectionId: "c1", ProjectId: "billing", ResourceId: "config", Version: "v1", Status: types.AckApplied}
+	if tracker.record(ack, nil, client.identity()) {
+		t.Fatal("ack of a connection not delivered to yet was counted")
+	}
+	if status, _ := tracker.status("billing", "config", "v1"); len(status.Applied) != 0 {
+		t.Fatalf("applied = %v before delivery", status.Applied)
+	}
+
+	tracker.delivered(resource, []*Client{client})
+	status, _ := tracker.status("billing", "config", "v1")
+	if !slices.Equal(status.Applied, []string{"c1"}) || len(status.Pending) != 0 {
+		t.Errorf("rollout = %+v, want the early ack applied", status)
+	}
+}
@@ -7,13 +7,26 @@ import (
 )
 
 type Adapter interface {
-	Broadcast(ctx context.Context, event types.ChangedEvent) error
+	Broadcast(ctx context.Context, event types.ChangedEvent) (DeliveryReport, error)
 	Run(ctx context.Context) error
 }
 
+// DeliveryReport tells what a broadcast did with the clients it addressed.
+// Every targeted client either got the event enqueued or was disconnected.
+type DeliveryReport struct {
+	Version      string `json:"version"`      // version of the resource, set by the operator when empty
+	Targeted     int    `json:"targeted"`     // clients addressed and allowed to receive the event
+	Enqueued     int    `json:"enqueued"`     // clients the event was queued to, or is to be replayed to
+	Dropped      int    `json:"dropped"`      // of them, clients that lost an older pending event to make room
+	Disconnected int    `json:"disconnected"` // clients removed for being too slow instead
+}
+
 type Hook struct {
 	OnConnected    []func(ctx context.Context, client *Client)
 	OnDisconnected []func(ctx context.Context, client *Client)
 }
 
-type CredentialVerifier func(ctx context.Context, apikey string, project string) error
+// CredentialVerifier checks a credential for a project and returns the
+// principal it belongs to. A nil principal with a nil error is treated as an
+// anonymous principal without permissions.
+type CredentialVerifier func(ctx context.Context, apikey string, project string) (*Principal, error)
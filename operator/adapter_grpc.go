@@ -17,7 +17,7 @@ func (a *AdapterGRPC) Credential(ctx context.Context, apikey string, project str
 }
 
 // OnChanged implements Adapter.
-func (a *AdapterGRPC) Broadcast(ctx context.Context, event types.ChangedEvent) error {
+func (a *AdapterGRPC) Broadcast(ctx context.Context, event types.ChangedEvent) (DeliveryReport, error) {
 	panic("unimplemented")
 }
 
@@ -2,14 +2,19 @@ package operator
 
 import (
 	"context"
+	"crypto/x509"
 	"encoding/base64"
 	"errors"
+	"fmt"
 	"log/slog"
 	"net/http"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/goccy/go-json"
 	"github.com/google/uuid"
+	"github.com/lamlv2305/sentinel/envelope"
 	"github.com/lamlv2305/sentinel/types"
 )
 
@@ -35,31 +40,145 @@ func WithOnDisconnectedHook(hook func(ctx context.Context, client *Client)) With
 	}
 }
 
+// WithSSEEventFilter restricts, beyond the principal's own scope, which
+// events a client receives, e.g. per principal label
+func WithSSEEventFilter(filter func(client *Client, event types.ChangedEvent) bool) WithSSE {
+	return func(s *SSE) {
+		s.filters = append(s.filters, filter)
+	}
+}
+
+// WithSSECredentialHeaders overrides the headers carrying the apikey and the
+// project, for clients that cannot use the Authorization header.
+func WithSSECredentialHeaders(apikeyHeader, projectHeader string) WithSSE {
+	return func(s *SSE) {
+		s.credentials.apikeyHeader = apikeyHeader
+		s.credentials.projectHeader = projectHeader
+	}
+}
+
+// WithSSEQueryCredentials accepts the apikey from the query string. Query
+// strings end up in access logs and proxy caches, so only enable it for
+// clients that cannot send headers.
+func WithSSEQueryCredentials() WithSSE {
+	return func(s *SSE) {
+		s.credentials.allowQuery = true
+	}
+}
+
+// WithSSETicketEndpoint serves, on the given endpoint, the exchange of a real
+// credential for a single-use stream ticket valid for ttl. Browser
+// EventSource clients connect with ?ticket=... instead of an apikey.
+func WithSSETicketEndpoint(endpoint string, ttl time.Duration) WithSSE {
+	return func(s *SSE) {
+		s.tickets = newTicketStore(endpoint, ttl)
+	}
+}
+
+// WithSSERevalidateInterval re-verifies the credential of every live
+// connection at the given interval and revokes those no longer valid
+func WithSSERevalidateInterval(interval time.Duration) WithSSE {
+	return func(s *SSE) {
+		s.revalidateInterval = interval
+	}
+}
+
+// WithSSEClientCertAuth authenticates clients presenting a verified TLS
+// client certificate through the mapper instead of the credential verifier.
+// The server's tls.Config decides whether certificates are requested or
+// required (ClientAuth) and which CAs are trusted (ClientCAs).
+func WithSSEClientCertAuth(mapper CertMapper) WithSSE {
+	return func(s *SSE) {
+		s.certMapper = mapper
+	}
+}
+
+// WithSSEPolicy authorizes every operation with role bindings instead of
+// principal permissions
+func WithSSEPolicy(policy *Policy) WithSSE {
+	return func(s *SSE) {
+		s.policy = policy
+	}
+}
+
+// WithSSEPublishEndpoint serves Publish on the given endpoint
+func WithSSEPublishEndpoint(endpoint string) WithSSE {
+	return func(s *SSE) {
+		s.publishEndpoint = endpoint
+	}
+}
+
+// WithSSEAdminEndpoint serves AdminHandler under the given prefix, e.g.
+// "/admin", protected by the admin action
+func WithSSEAdminEndpoint(prefix string) WithSSE {
+	return func(s *SSE) {
+		s.adminEndpoint = strings.TrimSuffix(prefix, "/")
+	}
+}
+
+// WithSSESealer encrypts resource data before it leaves the operator, so
+// only agents holding the project key can read it.
+func WithSSESealer(sealer *envelope.Sealer) WithSSE {
+	return func(s *SSE) {
+		s.sealer = sealer
+	}
+}
+
 type SSE struct {
-	mux      *http.ServeMux
-	endpoint string
-	hub      *hub
-	cv       CredentialVerifier
-	hook     Hook
+	mux         *http.ServeMux
+	endpoint    string
+	hub         *hub
+	cv          CredentialVerifier
+	certMapper  CertMapper
+	policy      *Policy
+	credentials credentialSource
+	tickets     *ticketStore
+	hook        Hook
+	filters     []func(client *Client, event types.ChangedEvent) bool
+	sealer      *envelope.Sealer
+
+	limits        *limiter
+	limitRecorder LimitRecorder
+
+	bufferSize   int
+	slowConsumer SlowConsumerPolicy
+
+	revalidateInterval time.Duration
+	publishEndpoint    string
+	adminEndpoint      string
+	ackEndpoint        string
+	acks               *ackTracker
+	rollouts           *rolloutController
 }
 
 func NewSSE(mux *http.ServeMux, endpoint string, opts ...WithSSE) *SSE {
 	ins := &SSE{
-		mux:      mux,
-		endpoint: endpoint,
-		hub:      defaultHub(),
-		cv:       nil,
-		hook:     Hook{},
+		mux:         mux,
+		endpoint:    endpoint,
+		hub:         defaultHub(),
+		cv:          nil,
+		credentials: defaultCredentialSource(),
+		hook:        Hook{},
+
+		limitRecorder: &LimitCounters{},
+		rollouts:      newRolloutController(),
+
+		bufferSize:   100,
+		slowConsumer: SlowConsumerDisconnect,
 	}
 
 	for _, opt := range opts {
 		opt(ins)
 	}
 
+	if ins.slowConsumer == SlowConsumerSpill && ins.hub.journal.size == 0 {
+		ins.hub.journal = newJournal(defaultSpillJournalSize)
+	}
+
 	if ins.cv == nil {
-		ins.cv = func(ctx context.Context, apikey string, project string) error {
+		ins.cv = func(ctx context.Context, apikey string, project string) (*Principal, error) {
 			slog.Error("Credential verifier not set")
-			return errors.New("credential verifier not set")
+			return nil, errors.New("credential verifier not set")
 		}
 	}
 
@@ -68,31 +187,109 @@ func NewSSE(mux *http.ServeMux, endpoint string, opts ...WithSSE) *SSE {
 
 func (s *SSE) Run(ctx context.Context) error {
 	s.mux.HandleFunc(s.endpoint, s.OnConnected)
+	if s.tickets != nil {
+		s.mux.HandleFunc(s.tickets.endpoint, s.IssueTicket)
+	}
+	if s.publishEndpoint != "" {
+		s.mux.HandleFunc(s.publishEndpoint, s.Publish)
+	}
+	if s.ackEndpoint != "" {
+		s.mux.HandleFunc(s.ackEndpoint, s.HandleAck)
+	}
+	if s.adminEndpoint != "" {
+		s.mux.Handle(s.adminEndpoint+"/", http.StripPrefix(s.adminEndpoint, s.AdminHandler(nil)))
+	}
 
 	ticker := time.NewTicker(1 * time.Minute)
 	defer ticker.Stop()
 
+	slowTicker := time.NewTicker(max(s.hub.sendTimeout, time.Second))
+	defer slowTicker.Stop()
+
+	var revalidate <-chan time.Time
+	if s.revalidateInterval > 0 {
+		revalidateTicker := time.NewTicker(s.revalidateInterval)
+		defer revalidateTicker.Stop()
+		revalidate = revalidateTicker.C
+	}
+
 	for {
 		select {
 		case <-ctx.Done():
 			return nil
 
+		case <-revalidate:
+			s.revalidate(ctx)
+
+		case <-slowTicker.C:
+			s.hub.evictSlow()
+
 		case <-ticker.C:
 			s.hub.cleanup() // Clean up disconnected clients
+			if s.tickets != nil {
+				s.tickets.cleanup()
+			}
+			s.cleanupLimits()
 		}
 	}
 }
 
-// OnChanged implements Adapter.
-func (s *SSE) Broadcast(ctx context.Context, event types.ChangedEvent) error {
+// OnChanged implements Adapter. Resources with an active staged rollout
+// fail with ErrRolloutInProgress; they change through the rollout.
+func (s *SSE) Broadcast(ctx context.Context, event types.ChangedEvent) (DeliveryReport, error) {
+	return s.broadcast(ctx, event, Target{})
+}
+
+func (s *SSE) broadcast(ctx context.Context, event types.ChangedEvent, target Target) (DeliveryReport, error) {
+	if s.rollouts.active(event.Resource.ProjectId, event.Resource.ResourceId) {
+		return DeliveryReport{}, fmt.Errorf("%w for %s/%s", ErrRolloutInProgress, event.Resource.ProjectId, event.Resource.ResourceId)
+	}
+
+	if err := s.allowPublish(event.Resource.ProjectId); err != nil {
+		return DeliveryReport{}, err
+	}
+
+	if event.Resource.Version == "" {
+		event.Resource.Version = uuid.New().String()
+	}
+
+	if s.acks != nil {
+		s.acks.publishing(event.Resource)
+	}
+
+	return s.send(ctx, event, target)
+}
+
+// send delivers a versioned event to the target. Unlike broadcast, it spends
+// no publish budget and leaves the tracked latest version alone, so staged
+// rollouts use it for every stage and to restore the previous version.
+func (s *SSE) send(ctx context.Context, event types.ChangedEvent, target Target) (DeliveryReport, error) {
+	if s.sealer != nil {
+		sealed, err := s.sealer.Seal(ctx, event.Resource)
+		if err != nil {
+			return DeliveryReport{}, err
+		}
+		event.Resource = sealed
+	}
+
 	data, err := json.Marshal(event)
 	if err != nil {
-		return err
+		return DeliveryReport{}, err
 	}
 
-	message := "data: " + base64.StdEncoding.EncodeToString(data)
-	s.hub.broadcast(event.Resource.ProjectId, message)
-	return nil
+	payload := "data: " + base64.StdEncoding.EncodeToString(data)
+
+	delivered := s.hub.broadcast(event, payload, target, func(c *Client) bool {
+		return s.accepts(c, event)
+	}, s.acks != nil)
+
+	if s.acks != nil {
+		s.acks.delivered(event.Resource, delivered.recipients)
+	}
+
+	report := delivered.report
+	report.Version = event.Resource.Version
+	return report, nil
 }
 
 func (s *SSE) OnConnected(w http.ResponseWriter, r *http.Request) {
@@ -103,21 +300,53 @@ func (s *SSE) OnConnected(w http.ResponseWriter, r *http.Request) {
 		}
 	}()
 
+	if err := s.allowConnect(r); err != nil {
+		writeRateLimited(w, err)
+		return
+	}
+
 	// Validate credentials
-	apikey := r.URL.Query().Get("apikey")
-	project := r.URL.Query().Get("project")
+	auth, status := s.authenticate(r)
+	if status == http.StatusOK && !s.authorizeAll(auth.principal, ActionSubscribe, auth.projects) {
+		slog.Warn("Rejected client subscription", "projects", auth.projects, "principal", auth.principal)
+		status = http.StatusForbidden
+	}
+	if status != http.StatusOK {
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+
+	subscription, err := parseSubscription(r)
+	if err != nil {
+		http.Error(w, "Invalid subscription", http.StatusBadRequest)
+		return
+	}
+
+	labels, err := parseLabels(r)
+	if err != nil {
+		http.Error(w, "Invalid labels", http.StatusBadRequest)
+		return
+	}
+
+	metadata, err := parseMetadata(r)
+	if err != nil {
+		http.Error(w, "Invalid agent metadata", http.StatusBadRequest)
+		return
+	}
 
-	if err := s.cv(r.Context(), apikey, project); err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	release, err := s.acquireConnection(auth.projects, auth.principal)
+	if err != nil {
+		writeRateLimited(w, err)
 		return
 	}
+	defer release()
 
 	// Setup SSE headers
 	w.Header().Set("Content-Type", "text/event-stream")
 	w.Header().Set("Cache-Control", "no-cache")
 	w.Header().Set("Connection", "keep-alive")
 	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Headers", "Cache-Control")
+	w.Header().Set("Access-Control-Allow-Headers", s.credentials.allowedHeaders())
 
 	// Flush headers immediately
 	flusher, ok := w.(http.Flusher)
@@ -129,27 +358,176 @@ func (s *SSE) OnConnected(w http.ResponseWriter, r *http.Request) {
 
 	// Create and register client
 	connectionId := uuid.New().String()
-	client := NewClient(connectionId, project)
+	client := NewClient(connectionId, auth.projects[0])
+	client.Projects = auth.projects
+	client.Principal = auth.principal
+	client.Subscription = subscription
+	client.Labels = labels
+	client.Metadata = metadata
+	client.credential = auth.credential
+	client.certificate = auth.certificate
+	client.outbox = newOutbox(s.bufferSize, s.slowConsumer)
+	if wantsCoalescing(r) {
+		client.outbox.coalesceUpdates()
+	}
+	s.startDelivery(r, client)
 	s.hub.add(client)
+	slog.Info("Client connected", "client", client)
+	s.rolloutConnected(r.Context(), client)
+	for _, hook := range s.hook.OnConnected {
+		hook(r.Context(), client)
+	}
+
 	defer func() {
-		client.Close()
-		s.hub.remove(project, connectionId)
+		s.hub.remove(client)
+		slog.Info("Client disconnected", "client", client)
+
+		// The request context is already canceled once the client is gone
+		ctx := context.WithoutCancel(r.Context())
+		for _, hook := range s.hook.OnDisconnected {
+			hook(ctx, client)
+		}
 	}()
 
 	// Send connection confirmation
-	connectionEvent := "data: {\"type\":\"connected\",\"id\":\"" + connectionId + "\"}\n\n"
-	if _, err := w.Write([]byte(connectionEvent)); err != nil {
+	connectionEvent := controlMessage(controlEvent{Type: types.ControlConnected, Id: connectionId})
+	if s.writeSSE(w, connectionEvent+"\n\n", flusher) != nil {
 		return
 	}
-	flusher.Flush()
 
 	// Start event loop
 	s.handleEvents(w, r, client, flusher)
 }
 
+// accepts reports whether the event may be delivered to the client
+func (s *SSE) accepts(client *Client, event types.ChangedEvent) bool {
+	if !client.accepts(event) || !client.Subscription.Matches(event.Resource) {
+		return false
+	}
+
+	if !s.Authorize(client.Principal, ActionSubscribe, event.Resource.ProjectId, event.Resource.Group) {
+		return false
+	}
+
+	for _, filter := range s.filters {
+		if !filter(client, event) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// clientAuth is the outcome of authenticating a connecting request
+type clientAuth struct {
+	principal   *Principal
+	projects    []string
+	credential  string
+	certificate *x509.Certificate
+}
+
+// authenticate resolves the principal of a connecting request: from its
+// verified client certificate when certificate authentication is enabled and
+// one was presented, otherwise from its credential. It returns the HTTP
+// status to reject the request with, or http.StatusOK.
+func (s *SSE) authenticate(r *http.Request) (clientAuth, int) {
+	var auth clientAuth
+
+	if s.certMapper != nil && r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		auth.certificate = r.TLS.VerifiedChains[0][0]
+		_, auth.projects = s.credentials.extract(r)
+
+		principal, err := s.certMapper(auth.certificate)
+		if err != nil {
+			slog.Warn("Rejected client certificate", "projects", auth.projects, "subject", auth.certificate.Subject.String(), "error", err)
+			return auth, http.StatusUnauthorized
+		}
+
+		if principal == nil {
+			principal = &Principal{}
+		}
+		auth.principal = principal
+
+		for _, project := range auth.projects {
+			if !principal.AllowsProject(project) {
+				slog.Warn("Rejected client project", "projectId", project, "principal", principal)
+				return auth, http.StatusForbidden
+			}
+		}
+
+		return auth, http.StatusOK
+	}
+
+	apikey, projects, ok := s.resolveCredentials(r)
+	if !ok {
+		return auth, http.StatusUnauthorized
+	}
+	auth.credential, auth.projects = apikey, projects
+
+	principal, status := s.verify(r, apikey, projects)
+	auth.principal = principal
+
+	return auth, status
+}
+
+// verify runs the credential verifier for each project. The principal is the
+// one verified for the first project.
+func (s *SSE) verify(r *http.Request, apikey string, projects []string) (*Principal, int) {
+	var first *Principal
+	for i, project := range projects {
+		principal, err := s.cv(r.Context(), apikey, project)
+		if err != nil {
+			slog.Warn("Rejected client credentials", "projectId", project, "error", err)
+			return nil, http.StatusUnauthorized
+		}
+
+		if principal == nil {
+			principal = &Principal{}
+		}
+
+		if !principal.AllowsProject(project) {
+			slog.Warn("Rejected client project", "projectId", project, "principal", principal)
+			return principal, http.StatusForbidden
+		}
+
+		if i == 0 {
+			first = principal
+		}
+	}
+
+	return first, http.StatusOK
+}
+
+// resolveCredentials returns the credential of a connecting client, redeeming
+// its stream ticket when it presents one
+func (s *SSE) resolveCredentials(r *http.Request) (apikey string, projects []string, ok bool) {
+	id := r.URL.Query().Get("ticket")
+	if id == "" || s.tickets == nil {
+		apikey, projects = s.credentials.extract(r)
+		return apikey, projects, true
+	}
+
+	tk, ok := s.tickets.consume(id)
+	if !ok {
+		return "", nil, false
+	}
+
+	// A ticket only grants the projects it was issued for
+	_, requested := s.credentials.extract(r)
+	if requested[0] != "" {
+		for _, project := range requested {
+			if !slices.Contains(tk.projects, project) {
+				return "", nil, false
+			}
+		}
+		return tk.apikey, requested, true
+	}
+
+	return tk.apikey, tk.projects, true
+}
+
 // handleEvents manages the SSE event loop for a connected client
 func (s *SSE) handleEvents(w http.ResponseWriter, r *http.Request, client *Client, flusher http.Flusher) {
-	clientCh := client.GetChannel()
 	keepalive := time.NewTicker(30 * time.Second)
 	defer keepalive.Stop()
 
@@ -157,11 +535,20 @@ func (s *SSE) handleEvents(w http.ResponseWriter, r *http.Request, client *Clien
 		select {
 		case <-r.Context().Done():
 			return
-		case message, ok := <-clientCh:
-			if !ok {
-				return
+		case <-client.done:
+			s.writeTerminal(w, client, flusher)
+			return
+		case <-client.outbox.ready:
+			for _, msg := range client.outbox.take() {
+				if s.writeMessage(w, client, msg, flusher) != nil {
+					return
+				}
 			}
-			if s.writeSSE(w, message+"\n\n", flusher) != nil {
+
+			if err := s.replay(w, client, flusher); errors.Is(err, errJournalGap) {
+				slog.Warn("Client fell behind the journal, disconnecting", "client", client)
+				return
+			} else if err != nil {
 				return
 			}
 		case <-keepalive.C:
@@ -172,6 +559,13 @@ func (s *SSE) handleEvents(w http.ResponseWriter, r *http.Request, client *Clien
 	}
 }
 
+// writeTerminal writes the client's final control message, if it has one
+func (s *SSE) writeTerminal(w http.ResponseWriter, client *Client, flusher http.Flusher) {
+	if message := client.terminalMessage(); message != "" {
+		_ = s.writeSSE(w, message+"\n\n", flusher)
+	}
+}
+
 // writeSSE writes SSE data and flushes
 func (s *SSE) writeSSE(w http.ResponseWriter, data string, flusher http.Flusher) error {
 	if _, err := w.Write([]byte(data)); err != nil {
@@ -0,0 +1,368 @@
+package operator
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"maps"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/goccy/go-json"
+	"github.com/lamlv2305/sentinel/types"
+)
+
+// Connection describes a live client for the admin API
+type Connection struct {
+	Id          string              `json:"id"`
+	Projects    []string            `json:"projects"`
+	Principal   *Principal          `json:"principal,omitempty"`
+	Labels      map[string]string   `json:"labels,omitempty"`
+	Metadata    types.AgentMetadata `json:"metadata"`
+	ConnectedAt time.Time           `json:"connected_at"`
+	LastSent    *time.Time          `json:"last_sent,omitempty"`
+	Buffered    int                 `json:"buffered"`
+	BufferSize  int                 `json:"buffer_size"`
+}
+
+func newConnection(c *Client) Connection {
+	conn := Connection{
+		Id:          c.Id,
+		Projects:    c.Projects,
+		Principal:   c.Principal,
+		Labels:      c.Labels,
+		Metadata:    c.Metadata,
+		ConnectedAt: c.ConnectedAt,
+		Buffered:    c.outbox.fill(),
+		BufferSize:  c.outbox.capacity,
+	}
+	if sent := c.LastSent(); !sent.IsZero() {
+		conn.LastSent = &sent
+	}
+
+	return conn
+}
+
+// Connections lists the live clients of the project, or of every project
+// when it is empty, whose labels and agent metadata match the selector
+func (s *SSE) Connections(projectId string, selector Selector) []Connection {
+	clients := s.hub.find(func(c *Client) bool {
+		return (projectId == "" || c.HasProject(projectId)) && selector.Matches(c.selectable)
+	})
+
+	slices.SortFunc(clients, func(a, b *Client) int {
+		return a.ConnectedAt.Compare(b.ConnectedAt)
+	})
+
+	connections := make([]Connection, len(clients))
+	for i, client := range clients {
+		connections[i] = newConnection(client)
+	}
+
+	return connections
+}
+
+// ConnectionCounts returns how many clients are connected to each project
+func (s *SSE) ConnectionCounts() map[string]int {
+	return s.hub.counts()
+}
+
+// Disconnect closes the connection with a "disconnected" control event. The
+// agent may connect again; use Revoke to keep it out. It reports whether the
+// connection was found.
+func (s *SSE) Disconnect(connectionId, reason string) bool {
+	clients := s.hub.find(func(c *Client) bool { return c.Id == connectionId })
+	for _, client := range clients {
+		s.disconnect(client, reason)
+	}
+
+	return len(clients) > 0
+}
+
+// DisconnectProject closes every connection to the project, see Disconnect.
+// It returns how many connections were closed.
+func (s *SSE) DisconnectProject(projectId, reason string) int {
+	clients := s.hub.find(func(c *Client) bool { return c.HasProject(projectId) })
+	for _, client := range clients {
+		s.disconnect(client, reason)
+	}
+
+	return len(clients)
+}
+
+func (s *SSE) disconnect(client *Client, reason string) {
+	slog.Info("Disconnecting client", "client", client, "reason", reason)
+	client.Terminate(controlMessage(controlEvent{Type: types.ControlDisconnected, Id: client.Id, Reason: reason}))
+}
+
+// adminScope tells whether the caller of the admin API may manage a project
+type adminScope func(project string) bool
+
+type adminScopeKey struct{}
+
+// AdminAuthorizer authenticates an admin request. It returns the status to
+// reject the request with, or http.StatusOK and the principal whose projects
+// the request may manage; a nil principal may manage every project.
+type AdminAuthorizer func(r *http.Request) (*Principal, int)
+
+// AdminHandler serves the admin API for live connections. With a nil
+// authorizer, requests authenticate like a connecting client and may only
+// manage the projects they were authenticated for on which they hold the
+// admin action: lists only show those projects, and connections or rollouts
+// of other projects are not found. A custom authorizer scopes requests the
+// same way to the projects of the principal it returns. Mount it under a
+// prefix with http.StripPrefix, e.g.
+// mux.Handle("/admin/", http.StripPrefix("/admin", sse.AdminHandler(nil))).
+//
+//	GET    /connections                     list connections, filtered by the project and selector query parameters
+//	GET    /connections/{id}                get a connection
+//	DELETE /connections/{id}                disconnect a connection
+//	GET    /projects                        connection counts per project
+//	DELETE /projects/{project}/connections  disconnect every connection to a project
+//	GET    /rollouts                        rollout status, by the project, resource and optional version query parameters
+//	GET    /staged-rollouts                 list staged rollouts
+//	GET    /staged-rollouts/{id}            get a staged rollout
+//	POST   /staged-rollouts/{id}/promote    promote a staged rollout to its next stage
+//	POST   /staged-rollouts/{id}/abort      abort a staged rollout, with an optional reason query parameter
+//
+// Disconnects take an optional reason query parameter.
+func (s *SSE) AdminHandler(authorize AdminAuthorizer) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /connections", s.handleListConnections)
+	mux.HandleFunc("GET /connections/{id}", s.handleGetConnection)
+	mux.HandleFunc("DELETE /connections/{id}", s.handleDisconnect)
+	mux.HandleFunc("GET /projects", s.handleConnectionCounts)
+	mux.HandleFunc("DELETE /projects/{project}/connections", s.handleDisconnectProject)
+	mux.HandleFunc("GET /rollouts", s.handleRolloutStatus)
+	mux.HandleFunc("GET /staged-rollouts", s.handleListRollouts)
+	mux.HandleFunc("GET /staged-rollouts/{id}", s.handleGetRollout)
+	mux.HandleFunc("POST /staged-rollouts/{id}/promote", s.handlePromoteRollout)
+	mux.HandleFunc("POST /staged-rollouts/{id}/abort", s.handleAbortRollout)
+
+	if authorize == nil {
+		authorize = s.authorizeAdmin
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, status := authorize(r)
+		if status != http.StatusOK {
+			http.Error(w, http.StatusText(status), status)
+			return
+		}
+
+		if principal == nil {
+			mux.ServeHTTP(w, r)
+			return
+		}
+
+		scope := adminScope(func(project string) bool {
+			return s.Authorize(principal, ActionAdmin, project, AnyGroup)
+		})
+		mux.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), adminScopeKey{}, scope)))
+	})
+}
+
+// authorizeAdmin authenticates an admin request like a connecting client,
+// requiring the admin action on at least one of its projects. The principal
+// is narrowed to the projects the request was authenticated for.
+func (s *SSE) authorizeAdmin(r *http.Request) (*Principal, int) {
+	auth, status := s.authenticate(r)
+	if status != http.StatusOK {
+		return nil, status
+	}
+
+	projects := slices.DeleteFunc(slices.Clone(auth.projects), func(project string) bool {
+		return !s.Authorize(auth.principal, ActionAdmin, project, AnyGroup)
+	})
+	if len(projects) == 0 {
+		return nil, http.StatusForbidden
+	}
+
+	principal := *auth.principal
+	principal.Projects = projects
+
+	return &principal, http.StatusOK
+}
+
+// manages reports whether the caller may manage every one of the projects
+func manages(r *http.Request, projects ...string) bool {
+	scope, ok := r.Context().Value(adminScopeKey{}).(adminScope)
+	if !ok {
+		return true
+	}
+
+	for _, project := range projects {
+		if !scope(project) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// managedClient returns the live connection of the path, if the caller may
+// manage it
+func (s *SSE) managedClient(r *http.Request) (*Client, bool) {
+	id := r.PathValue("id")
+	clients := s.hub.find(func(c *Client) bool { return c.Id == id })
+	if len(clients) == 0 || !manages(r, clients[0].Projects...) {
+		return nil, false
+	}
+
+	return clients[0], true
+}
+
+// managedRollout returns the staged rollout of the path, if the caller may
+// manage it
+func (s *SSE) managedRollout(w http.ResponseWriter, r *http.Request) (StagedRollout, bool) {
+	rollout, err := s.Rollout(r.PathValue("id"))
+	if err == nil && !manages(r, rollout.ProjectId) {
+		err = ErrRolloutNotFound
+	}
+	if err != nil {
+		writeRolloutError(w, err)
+		return StagedRollout{}, false
+	}
+
+	return rollout, true
+}
+
+func (s *SSE) handleListConnections(w http.ResponseWriter, r *http.Request) {
+	selector, err := ParseSelector(r.URL.Query().Get("selector"))
+	if err != nil {
+		http.Error(w, "Invalid selector", http.StatusBadRequest)
+		return
+	}
+
+	connections := s.Connections(r.URL.Query().Get("project"), selector)
+	managed := make([]Connection, 0, len(connections))
+	for _, conn := range connections {
+		if manages(r, conn.Projects...) {
+			managed = append(managed, conn)
+		}
+	}
+
+	writeJSON(w, http.StatusOK, managed)
+}
+
+func (s *SSE) handleGetConnection(w http.ResponseWriter, r *http.Request) {
+	client, ok := s.managedClient(r)
+	if !ok {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, newConnection(client))
+}
+
+func (s *SSE) handleDisconnect(w http.ResponseWriter, r *http.Request) {
+	client, ok := s.managedClient(r)
+	if !ok {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+
+	s.disconnect(client, r.URL.Query().Get("reason"))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *SSE) handleConnectionCounts(w http.ResponseWriter, r *http.Request) {
+	counts := s.ConnectionCounts()
+	maps.DeleteFunc(counts, func(project string, _ int) bool { return !manages(r, project) })
+
+	writeJSON(w, http.StatusOK, counts)
+}
+
+func (s *SSE) handleDisconnectProject(w http.ResponseWriter, r *http.Request) {
+	project := r.PathValue("project")
+	if !manages(r, project) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	count := s.DisconnectProject(project, r.URL.Query().Get("reason"))
+	writeJSON(w, http.StatusOK, map[string]int{"disconnected": count})
+}
+
+func (s *SSE) handleRolloutStatus(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if !manages(r, query.Get("project")) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	status, ok := s.RolloutStatus(query.Get("project"), query.Get("resource"), query.Get("version"))
+	if !ok {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, status)
+}
+
+func (s *SSE) handleListRollouts(w http.ResponseWriter, r *http.Request) {
+	rollouts := slices.DeleteFunc(s.Rollouts(), func(rollout StagedRollout) bool {
+		return !manages(r, rollout.ProjectId)
+	})
+
+	writeJSON(w, http.StatusOK, rollouts)
+}
+
+func (s *SSE) handleGetRollout(w http.ResponseWriter, r *http.Request) {
+	rollout, ok := s.managedRollout(w, r)
+	if !ok {
+		return
+	}
+
+	writeJSON(w, http.StatusOK, rollout)
+}
+
+func (s *SSE) handlePromoteRollout(w http.ResponseWriter, r *http.Request) {
+	if _, ok := s.managedRollout(w, r); !ok {
+		return
+	}
+
+	rollout, err := s.PromoteRollout(r.Context(), r.PathValue("id"))
+	if err != nil {
+		writeRolloutError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, rollout)
+}
+
+func (s *SSE) handleAbortRollout(w http.ResponseWriter, r *http.Request) {
+	if _, ok := s.managedRollout(w, r); !ok {
+		return
+	}
+
+	rollout, err := s.AbortRollout(r.Context(), r.PathValue("id"), r.URL.Query().Get("reason"))
+	if err != nil {
+		writeRolloutError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, rollout)
+}
+
+func writeRolloutError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrRolloutNotFound):
+		http.Error(w, "Not found", http.StatusNotFound)
+	case errors.Is(err, ErrRolloutFinished):
+		http.Error(w, "Rollout finished", http.StatusConflict)
+	case errors.Is(err, ErrRateLimited):
+		writeRateLimited(w, err)
+	default:
+		slog.Error("Rollout admin request failed", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,133 @@
+package operator
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/goccy/go-json"
+	"github.com/lamlv2305/sentinel/types"
+)
+
+func TestAdminHandlerScope(t *testing.T) {
+	s := NewSSE(http.NewServeMux(), "/sse", WithSSECredentialVerifier(func(ctx context.Context, apikey, project string) (*Principal, error) {
+		if apikey != "admin-billing" {
+			return nil, errors.New("unknown key")
+		}
+		return &Principal{Subject: "admin", Projects: []string{"billing"}, Permissions: []Permission{PermissionAdmin}}, nil
+	}))
+
+	billing, payroll := NewClient("c-billing", "billing"), NewClient("c-payroll", "payroll")
+	s.hub.add(billing)
+	s.hub.add(payroll)
+
+	handler := s.AdminHandler(nil)
+	do := func(method, path, project string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, nil)
+		r.Header.Set(types.HeaderAPIKey, "admin-billing")
+		r.Header.Set(types.HeaderProject, project)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	tests := []struct {
+		name    string
+		method  string
+		path    string
+		project string
+		status  int
+	}{
+		{name: "other project header", method: http.MethodGet, path: "/connections", project: "payroll", status: http.StatusForbidden},
+		{name: "own connection", method: http.MethodGet, path: "/connections/c-billing", project: "billing", status: http.StatusOK},
+		{name: "other project connection", method: http.MethodGet, path: "/connections/c-payroll", project: "billing", status: http.StatusNotFound},
+		{name: "disconnect other project connection", method: http.MethodDelete, path: "/connections/c-payroll", project: "billing", status: http.StatusNotFound},
+		{name: "disconnect other project", method: http.MethodDelete, path: "/projects/payroll/connections", project: "billing", status: http.StatusForbidden},
+		{name: "other project rollout status", method: http.MethodGet, path: "/rollouts?project=payroll&resource=config", project: "billing", status: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if w := do(tt.method, tt.path, tt.project); w.Code != tt.status {
+				t.Fatalf("status = %d, want %d", w.Code, tt.status)
+			}
+		})
+	}
+
+	if !payroll.IsConnected() {
+		t.Fatal("connection of another project was disconnected")
+	}
+
+	var connections []Connection
+	if err := json.NewDecoder(do(http.MethodGet, "/connections", "billing").Body).Decode(&connections); err != nil {
+		t.Fatal(err)
+	}
+	if len(connections) != 1 || connections[0].Id != "c-billing" {
+		t.Errorf("connections = %+v, want c-billing only", connections)
+	}
+
+	var counts map[string]int
+	if err := json.NewDecoder(do(http.MethodGet, "/projects", "billing").Body).Decode(&counts); err != nil {
+		t.Fatal(err)
+	}
+	if len(counts) != 1 || counts["billing"] != 1 {
+		t.Errorf("counts = %v, want billing only", counts)
+	}
+}
+
+func TestAdminHandlerCustomAuthorizer(t *testing.T) {
+	s := NewSSE(http.NewServeMux(), "/sse")
+	s.hub.add(NewClient("c-billing", "billing"))
+	s.hub.add(NewClient("c-payroll", "payroll"))
+
+	tests := []struct {
+		name      string
+		principal *Principal
+		status    int
+		want      []string
+	}{
+		{name: "scoped principal", principal: &Principal{Subject: "ops", Projects: []string{"billing"}, Permissions: []Permission{PermissionAdmin}}, status: http.StatusOK, want: []string{"c-billing"}},
+		{name: "without admin permission", principal: &Principal{Subject: "ops", Projects: []string{"billing"}}, status: http.StatusOK, want: []string{}},
+		{name: "nil principal", status: http.StatusOK, want: []string{"c-billing", "c-payroll"}},
+		{name: "rejected", status: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := s.AdminHandler(func(r *http.Request) (*Principal, int) { return tt.principal, tt.status })
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/connections", nil))
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d", w.Code, tt.status)
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+
+			var connections []Connection
+			if err := json.NewDecoder(w.Body).Decode(&connections); err != nil {
+				t.Fatal(err)
+			}
+			ids := make([]string, len(connections))
+			for i, conn := range connections {
+				ids[i] = conn.Id
+			}
+			if !slices.Equal(ids, tt.want) {
+				t.Errorf("connections = %v, want %v", ids, tt.want)
+			}
+		})
+	}
+
+	handler := s.AdminHandler(func(r *http.Request) (*Principal, int) {
+		return &Principal{Subject: "ops", Projects: []string{"billing"}, Permissions: []Permission{PermissionAdmin}}, http.StatusOK
+	})
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/projects/payroll/connections", nil))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("disconnect other project: status = %d, want %d", w.Code, http.StatusForbidden)
+	}
+}
@@ -0,0 +1,280 @@
+package apikey
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/goccy/go-json"
+)
+
+type createRequest struct {
+	Name     string   `json:"name"`
+	Projects []string `json:"projects"`
+	Groups   []string `json:"groups,omitempty"`
+	Roles    []Role   `json:"roles"`
+	TTL      string   `json:"ttl,omitempty"` // Go duration, e.g. "720h"
+}
+
+// DefaultRotateOverlap is how long a rotated key stays valid when no overlap
+// is given
+const DefaultRotateOverlap = 24 * time.Hour
+
+type rotateRequest struct {
+	Overlap string `json:"overlap,omitempty"` // Go duration, e.g. "24h"
+}
+
+type secretResponse struct {
+	Secret string `json:"secret"`
+	Key    Key    `json:"key"`
+}
+
+// Authorizer decides whether an admin request may proceed. It returns the
+// status to reject the request with, or http.StatusOK.
+type Authorizer func(r *http.Request) int
+
+type callerContextKey struct{}
+
+// Handler serves the admin API. With a nil authorizer every request must
+// carry a key of this store with the admin role as "Authorization: Bearer
+// sk_...", and only manages keys whose projects are all among the caller's.
+// Keys with the secrets role can only be created by callers holding it. To
+// enforce an operator policy instead, which then has to scope requests on its
+// own, pass
+//
+//	func(r *http.Request) int {
+//		_, status := sse.AuthorizeRequest(r, operator.ActionAdmin)
+//		return status
+//	}
+//
+// Mount it under a prefix with http.StripPrefix, e.g.
+// mux.Handle("/admin/", http.StripPrefix("/admin", store.Handler(nil))).
+//
+//	GET    /keys             list keys
+//	POST   /keys             create a key
+//	GET    /keys/{id}        get a key
+//	DELETE /keys/{id}        revoke a key
+//	POST   /keys/{id}/rotate rotate a key
+func (s *Store) Handler(authorize Authorizer) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /keys", s.handleList)
+	mux.HandleFunc("POST /keys", s.handleCreate)
+	mux.HandleFunc("GET /keys/{id}", s.handleGet)
+	mux.HandleFunc("DELETE /keys/{id}", s.handleRevoke)
+	mux.HandleFunc("POST /keys/{id}/rotate", s.handleRotate)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if authorize != nil {
+			if status := authorize(r); status != http.StatusOK {
+				http.Error(w, http.StatusText(status), status)
+				return
+			}
+
+			mux.ServeHTTP(w, r)
+			return
+		}
+
+		caller, status := s.authorizeAdmin(r)
+		if status != http.StatusOK {
+			http.Error(w, http.StatusText(status), status)
+			return
+		}
+
+		mux.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), callerContextKey{}, caller)))
+	})
+}
+
+// authorizeAdmin requires a key of this store with the admin role
+func (s *Store) authorizeAdmin(r *http.Request) (Key, int) {
+	scheme, apikey, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !strings.EqualFold(scheme, "bearer") {
+		return Key{}, http.StatusUnauthorized
+	}
+
+	key, err := s.Authenticate(r.Context(), strings.TrimSpace(apikey))
+	if err != nil {
+		return Key{}, http.StatusUnauthorized
+	}
+
+	if !key.HasRole(RoleAdmin) {
+		return Key{}, http.StatusForbidden
+	}
+
+	return key, http.StatusOK
+}
+
+// manages reports whether the caller authenticated by the default authorizer,
+// if any, may manage keys of the projects with the roles
+func manages(r *http.Request, projects []string, roles []Role) bool {
+	caller, ok := r.Context().Value(callerContextKey{}).(Key)
+	if !ok {
+		return true
+	}
+
+	// Same wildcard rule as the principal the caller authenticates as
+	principal := caller.Principal()
+	for _, project := range projects {
+		if !principal.AllowsProject(project) {
+			return false
+		}
+	}
+
+	return !slices.Contains(roles, RoleSecrets) || caller.HasRole(RoleSecrets)
+}
+
+func (s *Store) handleList(w http.ResponseWriter, r *http.Request) {
+	keys, err := s.List(r.Context())
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	visible := make([]Key, 0, len(keys))
+	for _, key := range keys {
+		if manages(r, key.Projects, nil) {
+			visible = append(visible, key)
+		}
+	}
+
+	writeJSON(w, http.StatusOK, visible)
+}
+
+func (s *Store) handleCreate(w http.ResponseWriter, r *http.Request) {
+	var req createRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	params := CreateParams{
+		Name:     req.Name,
+		Projects: req.Projects,
+		Groups:   req.Groups,
+		Roles:    req.Roles,
+	}
+
+	if req.TTL != "" {
+		ttl, err := time.ParseDuration(req.TTL)
+		if err != nil {
+			http.Error(w, "Invalid ttl", http.StatusBadRequest)
+			return
+		}
+		params.TTL = ttl
+	}
+
+	if err := params.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if !manages(r, params.Projects, params.Roles) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	secret, key, err := s.Create(r.Context(), params)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, secretResponse{Secret: secret, Key: key})
+}
+
+func (s *Store) handleGet(w http.ResponseWriter, r *http.Request) {
+	key, ok := s.managedKey(w, r)
+	if !ok {
+		return
+	}
+
+	writeJSON(w, http.StatusOK, key)
+}
+
+// managedKey loads the key of the path, writing an error unless the caller
+// may manage it. Keys out of the caller's scope are reported as not found.
+func (s *Store) managedKey(w http.ResponseWriter, r *http.Request) (Key, bool) {
+	key, err := s.Get(r.Context(), r.PathValue("id"))
+	if err == nil && !manages(r, key.Projects, nil) {
+		err = ErrNotFound
+	}
+	if err != nil {
+		writeError(w, err)
+		return Key{}, false
+	}
+
+	return key, true
+}
+
+func (s *Store) handleRevoke(w http.ResponseWriter, r *http.Request) {
+	if _, ok := s.managedKey(w, r); !ok {
+		return
+	}
+
+	if err := s.Revoke(r.Context(), r.PathValue("id")); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Store) handleRotate(w http.ResponseWriter, r *http.Request) {
+	key, ok := s.managedKey(w, r)
+	if !ok {
+		return
+	}
+
+	// Rotating hands out a new secret with the same roles
+	if !manages(r, key.Projects, key.Roles) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	var req rotateRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+	}
+
+	overlap := DefaultRotateOverlap
+	if req.Overlap != "" {
+		var err error
+		if overlap, err = time.ParseDuration(req.Overlap); err != nil {
+			http.Error(w, "Invalid overlap", http.StatusBadRequest)
+			return
+		}
+	}
+
+	secret, key, err := s.Rotate(r.Context(), r.PathValue("id"), overlap)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, secretResponse{Secret: secret, Key: key})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrNotFound):
+		http.Error(w, "Not found", http.StatusNotFound)
+	case errors.Is(err, ErrRevoked):
+		http.Error(w, "Key revoked", http.StatusConflict)
+	default:
+		slog.Error("API key admin request failed", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
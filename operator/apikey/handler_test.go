@@ -0,0 +1,146 @@
+package apikey
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/goccy/go-json"
+)
+
+func TestHandlerScope(t *testing.T) {
+	ctx := context.Background()
+	s, now := newTestStore(t)
+
+	create := func(projects []string, roles ...Role) (string, Key) {
+		secret, key, err := s.Create(ctx, CreateParams{Name: "k", Projects: projects, Roles: roles})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return secret, key
+	}
+
+	global, _ := create([]string{"*"}, RoleAdmin)
+	billingAdmin, _ := create([]string{"billing"}, RoleAdmin)
+	reader, _ := create([]string{"billing"}, RoleRead)
+	_, billingKey := create([]string{"billing"}, RoleRead)
+	_, payrollKey := create([]string{"payroll"}, RoleRead)
+	_, secretsKey := create([]string{"billing"}, RoleSecrets)
+
+	handler := s.Handler(nil)
+	serve := func(caller, method, path string, body any) *httptest.ResponseRecorder {
+		var data []byte
+		if body != nil {
+			data, _ = json.Marshal(body)
+		}
+
+		r := httptest.NewRequest(method, path, bytes.NewReader(data))
+		if caller != "" {
+			r.Header.Set("Authorization", "Bearer "+caller)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	tests := []struct {
+		name   string
+		caller string
+		method string
+		path   string
+		body   any
+		status int
+	}{
+		{name: "no credentials", method: http.MethodGet, path: "/keys", status: http.StatusUnauthorized},
+		{name: "unknown key", caller: "sk_0000000000000000_x", method: http.MethodGet, path: "/keys", status: http.StatusUnauthorized},
+		{name: "not an admin", caller: reader, method: http.MethodGet, path: "/keys", status: http.StatusForbidden},
+		{name: "get in scope", caller: billingAdmin, method: http.MethodGet, path: "/keys/" + billingKey.Id, status: http.StatusOK},
+		{name: "get out of scope", caller: billingAdmin, method: http.MethodGet, path: "/keys/" + payrollKey.Id, status: http.StatusNotFound},
+		{name: "wildcard caller gets any project", caller: global, method: http.MethodGet, path: "/keys/" + payrollKey.Id, status: http.StatusOK},
+		{name: "create in scope", caller: billingAdmin, method: http.MethodPost, path: "/keys", body: createRequest{Name: "n", Projects: []string{"billing"}, Roles: []Role{RoleRead}}, status: http.StatusCreated},
+		{name: "create out of scope", caller: billingAdmin, method: http.MethodPost, path: "/keys", body: createRequest{Name: "n", Projects: []string{"payroll"}, Roles: []Role{RoleRead}}, status: http.StatusForbidden},
+		{name: "create with wildcard", caller: billingAdmin, method: http.MethodPost, path: "/keys", body: createRequest{Name: "n", Projects: []string{"*"}, Roles: []Role{RoleRead}}, status: http.StatusForbidden},
+		{name: "wildcard caller creates for a project", caller: global, method: http.MethodPost, path: "/keys", body: createRequest{Name: "n", Projects: []string{"payroll"}, Roles: []Role{RoleRead}}, status: http.StatusCreated},
+		{name: "create secrets without holding it", caller: global, method: http.MethodPost, path: "/keys", body: createRequest{Name: "n", Projects: []string{"billing"}, Roles: []Role{RoleSecrets}}, status: http.StatusForbidden},
+		{name: "create invalid", caller: global, method: http.MethodPost, path: "/keys", body: createRequest{Name: "n", Projects: []string{"billing"}}, status: http.StatusBadRequest},
+		{name: "create invalid ttl", caller: global, method: http.MethodPost, path: "/keys", body: createRequest{Name: "n", Projects: []string{"billing"}, Roles: []Role{RoleRead}, TTL: "soon"}, status: http.StatusBadRequest},
+		{name: "rotate secrets without holding it", caller: global, method: http.MethodPost, path: "/keys/" + secretsKey.Id + "/rotate", status: http.StatusForbidden},
+		{name: "rotate invalid overlap", caller: global, method: http.MethodPost, path: "/keys/" + billingKey.Id + "/rotate", body: rotateRequest{Overlap: "soon"}, status: http.StatusBadRequest},
+		{name: "revoke out of scope", caller: billingAdmin, method: http.MethodDelete, path: "/keys/" + payrollKey.Id, status: http.StatusNotFound},
+		{name: "revoke missing", caller: global, method: http.MethodDelete, path: "/keys/missing", status: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if w := serve(tt.caller, tt.method, tt.path, tt.body); w.Code != tt.status {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+		})
+	}
+
+	t.Run("list", func(t *testing.T) {
+		w := serve(billingAdmin, http.MethodGet, "/keys", nil)
+		var keys []Key
+		if err := json.NewDecoder(w.Body).Decode(&keys); err != nil {
+			t.Fatal(err)
+		}
+		for _, key := range keys {
+			if !slices.Equal(key.Projects, []string{"billing"}) {
+				t.Errorf("listed %+v, out of the caller's scope", key)
+			}
+		}
+	})
+
+	t.Run("rotate and revoke", func(t *testing.T) {
+		w := serve(billingAdmin, http.MethodPost, "/keys/"+billingKey.Id+"/rotate", nil)
+		if w.Code != http.StatusCreated {
+			t.Fatalf("rotate status = %d: %s", w.Code, w.Body)
+		}
+
+		var rotated secretResponse
+		if err := json.NewDecoder(w.Body).Decode(&rotated); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.Authenticate(ctx, rotated.Secret); err != nil {
+			t.Errorf("rotated secret: %v", err)
+		}
+
+		old, err := s.Get(ctx, billingKey.Id)
+		if err != nil || !old.ExpiresAt.Equal(now.Add(DefaultRotateOverlap)) {
+			t.Errorf("old key expires at %v, want the default overlap", old.ExpiresAt)
+		}
+
+		if w := serve(billingAdmin, http.MethodDelete, "/keys/"+rotated.Key.Id, nil); w.Code != http.StatusNoContent {
+			t.Fatalf("revoke status = %d", w.Code)
+		}
+		if w := serve(billingAdmin, http.MethodPost, "/keys/"+rotated.Key.Id+"/rotate", rotateRequest{Overlap: time.Hour.String()}); w.Code != http.StatusConflict {
+			t.Errorf("rotate revoked status = %d, want %d", w.Code, http.StatusConflict)
+		}
+	})
+}
+
+func TestHandlerCustomAuthorizer(t *testing.T) {
+	s, _ := newTestStore(t)
+
+	handler := s.Handler(func(r *http.Request) int {
+		if r.Header.Get("X-Admin") != "yes" {
+			return http.StatusForbidden
+		}
+		return http.StatusOK
+	})
+
+	for header, want := range map[string]int{"": http.StatusForbidden, "yes": http.StatusOK} {
+		r := httptest.NewRequest(http.MethodGet, "/keys", nil)
+		r.Header.Set("X-Admin", header)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		if w.Code != want {
+			t.Errorf("X-Admin %q: status = %d, want %d", header, w.Code, want)
+		}
+	}
+}
@@ -0,0 +1,461 @@
+package apikey
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/lamlv2305/sentinel/operator"
+	_ "modernc.org/sqlite"
+)
+
+var (
+	ErrNotFound      = errors.New("apikey: key not found")
+	ErrInvalidKey    = errors.New("apikey: invalid key")
+	ErrRevoked       = errors.New("apikey: key revoked")
+	ErrExpired       = errors.New("apikey: key expired")
+	ErrProjectDenied = errors.New("apikey: project not allowed")
+)
+
+// keyPrefix starts every issued key: sk_<id>_<secret>
+const keyPrefix = "sk_"
+
+// lastUsedResolution throttles last-used writes to one per key per interval
+const lastUsedResolution = time.Minute
+
+type Role string
+
+const (
+	RoleRead    Role = "read"
+	RolePublish Role = "publish"
+	RoleSecrets Role = "secrets"
+	RoleAdmin   Role = "admin"
+)
+
+// permissions maps roles onto the operator permissions they grant
+var permissions = map[Role][]operator.Permission{
+	RoleRead:    {operator.PermissionSubscribe},
+	RolePublish: {operator.PermissionPublish},
+	RoleSecrets: {operator.PermissionSecrets},
+	RoleAdmin:   {operator.PermissionAdmin},
+}
+
+// Key is the stored metadata of an API key; the secret itself is only ever
+// returned once, when the key is created or rotated
+type Key struct {
+	Id         string     `json:"id"`
+	Name       string     `json:"name"`
+	Projects   []string   `json:"projects"`
+	Groups     []string   `json:"groups,omitempty"`
+	Roles      []Role     `json:"roles"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	RotatedTo  string     `json:"rotated_to,omitempty"`
+}
+
+// HasRole reports whether the key holds the role
+func (k Key) HasRole(role Role) bool {
+	return slices.Contains(k.Roles, role)
+}
+
+// Principal returns the identity the key authenticates as
+func (k Key) Principal() *operator.Principal {
+	principal := &operator.Principal{
+		Subject:  "apikey:" + k.Id,
+		KeyId:    k.Id,
+		Labels:   map[string]string{"name": k.Name},
+		Projects: k.Projects,
+		Groups:   k.Groups,
+	}
+
+	for _, role := range k.Roles {
+		principal.Permissions = append(principal.Permissions, permissions[role]...)
+	}
+
+	return principal
+}
+
+// CreateParams describes a new key
+type CreateParams struct {
+	Name     string        `json:"name"`
+	Projects []string      `json:"projects"`
+	Groups   []string      `json:"groups,omitempty"`
+	Roles    []Role        `json:"roles"`
+	TTL      time.Duration `json:"-"` // zero means the key never expires
+}
+
+func (p CreateParams) validate() error {
+	if p.Name == "" {
+		return errors.New("apikey: name is required")
+	}
+
+	if len(p.Projects) == 0 {
+		return errors.New("apikey: at least one project is required")
+	}
+
+	if len(p.Roles) == 0 {
+		return errors.New("apikey: at least one role is required")
+	}
+
+	for _, role := range p.Roles {
+		if _, ok := permissions[role]; !ok {
+			return fmt.Errorf("apikey: unknown role %q", role)
+		}
+	}
+
+	return nil
+}
+
+// Store keeps hashed API keys in SQLite
+type Store struct {
+	db       *sql.DB
+	now      func() time.Time
+	onRevoke []func(ctx context.Context, key Key)
+}
+
+func NewStore(filepath string) (*Store, error) {
+	u := url.URL{
+		Scheme: "file",
+		Path:   filepath,
+	}
+
+	q := u.Query()
+	q.Set("_journal_mode", "WAL")
+	q.Set("_busy_timeout", "5000")
+	u.RawQuery = q.Encode()
+
+	db, err := sql.Open("sqlite", u.String())
+	if err != nil {
+		return nil, err
+	}
+
+	createTableSQL := `
+	CREATE TABLE IF NOT EXISTS api_keys (
+		id TEXT PRIMARY KEY,
+		name TEXT NOT NULL,
+		hash TEXT NOT NULL,
+		projects TEXT NOT NULL,
+		key_groups TEXT NOT NULL,
+		roles TEXT NOT NULL,
+		created_at INTEGER NOT NULL,
+		expires_at INTEGER,
+		last_used_at INTEGER,
+		revoked_at INTEGER,
+		rotated_to TEXT
+	);`
+
+	if _, err := db.Exec(createTableSQL); err != nil {
+		return nil, fmt.Errorf("failed to create table: %w", err)
+	}
+
+	return &Store{
+		db:  db,
+		now: time.Now,
+	}, nil
+}
+
+// Close closes the database connection
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// OnRevoke registers a callback run after a key is revoked
+func (s *Store) OnRevoke(fn func(ctx context.Context, key Key)) {
+	s.onRevoke = append(s.onRevoke, fn)
+}
+
+// Create stores a new key and returns its secret form, which is not kept
+func (s *Store) Create(ctx context.Context, params CreateParams) (string, Key, error) {
+	if err := params.validate(); err != nil {
+		return "", Key{}, err
+	}
+
+	id, secret, err := generate()
+	if err != nil {
+		return "", Key{}, err
+	}
+
+	key := Key{
+		Id:        id,
+		Name:      params.Name,
+		Projects:  params.Projects,
+		Groups:    params.Groups,
+		Roles:     params.Roles,
+		CreatedAt: s.now().UTC().Truncate(time.Second),
+	}
+	if params.TTL > 0 {
+		expiresAt := key.CreatedAt.Add(params.TTL)
+		key.ExpiresAt = &expiresAt
+	}
+
+	projects, _ := json.Marshal(key.Projects)
+	groups, _ := json.Marshal(key.Groups)
+	roles, _ := json.Marshal(key.Roles)
+
+	query := `INSERT INTO api_keys (id, name, hash, projects, key_groups, roles, created_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
+	_, err = s.db.ExecContext(ctx, query, key.Id, key.Name, hash(secret),
+		string(projects), string(groups), string(roles), key.CreatedAt.Unix(), unixOrNil(key.ExpiresAt))
+	if err != nil {
+		return "", Key{}, fmt.Errorf("failed to save key: %w", err)
+	}
+
+	return keyPrefix + id + "_" + secret, key, nil
+}
+
+// Get returns the metadata of a key
+func (s *Store) Get(ctx context.Context, id string) (Key, error) {
+	row := s.db.QueryRowContext(ctx, `SELECT `+keyColumns+` FROM api_keys WHERE id = ?`, id)
+
+	key, _, err := scanKey(row, false)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Key{}, ErrNotFound
+	}
+
+	return key, err
+}
+
+// List returns every key, newest first
+func (s *Store) List(ctx context.Context) ([]Key, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+keyColumns+` FROM api_keys ORDER BY created_at DESC, id`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query keys: %w", err)
+	}
+	defer rows.Close()
+
+	var keys []Key
+	for rows.Next() {
+		key, _, err := scanKey(rows, false)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating rows: %w", err)
+	}
+
+	return keys, nil
+}
+
+// Revoke disables a key immediately
+func (s *Store) Revoke(ctx context.Context, id string) error {
+	result, err := s.db.ExecContext(ctx, `UPDATE api_keys SET revoked_at = ? WHERE id = ? AND revoked_at IS NULL`, s.now().Unix(), id)
+	if err != nil {
+		return fmt.Errorf("failed to revoke key: %w", err)
+	}
+
+	if n, _ := result.RowsAffected(); n == 0 {
+		if _, err := s.Get(ctx, id); err != nil {
+			return err
+		}
+		return nil // already revoked
+	}
+
+	key, err := s.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	for _, fn := range s.onRevoke {
+		fn(ctx, key)
+	}
+
+	return nil
+}
+
+// Rotate issues a replacement key with the same scope. The old key keeps
+// working for the overlap period so clients can switch without downtime.
+func (s *Store) Rotate(ctx context.Context, id string, overlap time.Duration) (string, Key, error) {
+	old, err := s.Get(ctx, id)
+	if err != nil {
+		return "", Key{}, err
+	}
+
+	if old.RevokedAt != nil {
+		return "", Key{}, ErrRevoked
+	}
+
+	params := CreateParams{
+		Name:     old.Name,
+		Projects: old.Projects,
+		Groups:   old.Groups,
+		Roles:    old.Roles,
+	}
+	if old.ExpiresAt != nil {
+		params.TTL = old.ExpiresAt.Sub(old.CreatedAt)
+	}
+
+	secret, key, err := s.Create(ctx, params)
+	if err != nil {
+		return "", Key{}, err
+	}
+
+	retireAt := s.now().Add(overlap)
+	if old.ExpiresAt != nil && old.ExpiresAt.Before(retireAt) {
+		retireAt = *old.ExpiresAt
+	}
+
+	query := `UPDATE api_keys SET expires_at = ?, rotated_to = ? WHERE id = ?`
+	if _, err := s.db.ExecContext(ctx, query, retireAt.Unix(), key.Id, old.Id); err != nil {
+		return "", Key{}, fmt.Errorf("failed to retire key: %w", err)
+	}
+
+	return secret, key, nil
+}
+
+// Authenticate resolves a secret key to its metadata, rejecting unknown,
+// revoked and expired keys, and records its use
+func (s *Store) Authenticate(ctx context.Context, apikey string) (Key, error) {
+	id, secret, ok := parse(apikey)
+	if !ok {
+		return Key{}, ErrInvalidKey
+	}
+
+	row := s.db.QueryRowContext(ctx, `SELECT `+keyColumns+`, hash FROM api_keys WHERE id = ?`, id)
+	key, stored, err := scanKey(row, true)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Key{}, ErrInvalidKey
+	}
+	if err != nil {
+		return Key{}, err
+	}
+
+	if subtle.ConstantTimeCompare([]byte(stored), []byte(hash(secret))) != 1 {
+		return Key{}, ErrInvalidKey
+	}
+
+	now := s.now()
+	if key.RevokedAt != nil {
+		return Key{}, ErrRevoked
+	}
+
+	if key.ExpiresAt != nil && !now.Before(*key.ExpiresAt) {
+		return Key{}, ErrExpired
+	}
+
+	query := `UPDATE api_keys SET last_used_at = ? WHERE id = ? AND (last_used_at IS NULL OR last_used_at < ?)`
+	if _, err := s.db.ExecContext(ctx, query, now.Unix(), id, now.Add(-lastUsedResolution).Unix()); err != nil {
+		return Key{}, fmt.Errorf("failed to record key use: %w", err)
+	}
+
+	return key, nil
+}
+
+// Verify implements operator.CredentialVerifier.
+func (s *Store) Verify(ctx context.Context, apikey string, project string) (*operator.Principal, error) {
+	key, err := s.Authenticate(ctx, apikey)
+	if err != nil {
+		return nil, err
+	}
+
+	principal := key.Principal()
+	if !principal.AllowsProject(project) {
+		return nil, ErrProjectDenied
+	}
+
+	return principal, nil
+}
+
+const keyColumns = `id, name, projects, key_groups, roles, created_at, expires_at, last_used_at, revoked_at, rotated_to`
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+// scanKey reads keyColumns, followed by the hash column when withHash is set
+func scanKey(row scanner, withHash bool) (Key, string, error) {
+	var key Key
+	var projects, groups, roles string
+	var createdAt int64
+	var expiresAt, lastUsedAt, revokedAt sql.NullInt64
+	var rotatedTo sql.NullString
+	var stored string
+
+	dest := []any{&key.Id, &key.Name, &projects, &groups, &roles, &createdAt,
+		&expiresAt, &lastUsedAt, &revokedAt, &rotatedTo}
+
+	if withHash {
+		dest = append(dest, &stored)
+	}
+
+	if err := row.Scan(dest...); err != nil {
+		return Key{}, "", err
+	}
+
+	if err := json.Unmarshal([]byte(projects), &key.Projects); err != nil {
+		return Key{}, "", fmt.Errorf("failed to decode projects: %w", err)
+	}
+	if err := json.Unmarshal([]byte(groups), &key.Groups); err != nil {
+		return Key{}, "", fmt.Errorf("failed to decode groups: %w", err)
+	}
+	if err := json.Unmarshal([]byte(roles), &key.Roles); err != nil {
+		return Key{}, "", fmt.Errorf("failed to decode roles: %w", err)
+	}
+
+	key.CreatedAt = time.Unix(createdAt, 0).UTC()
+	key.ExpiresAt = timeOrNil(expiresAt)
+	key.LastUsedAt = timeOrNil(lastUsedAt)
+	key.RevokedAt = timeOrNil(revokedAt)
+	key.RotatedTo = rotatedTo.String
+
+	return key, stored, nil
+}
+
+func generate() (id string, secret string, err error) {
+	raw := make([]byte, 8+32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", "", fmt.Errorf("failed to generate key: %w", err)
+	}
+
+	return hex.EncodeToString(raw[:8]), base64.RawURLEncoding.EncodeToString(raw[8:]), nil
+}
+
+// parse splits sk_<id>_<secret>; the id is hex so the first "_" ends it
+func parse(apikey string) (id string, secret string, ok bool) {
+	rest, found := strings.CutPrefix(apikey, keyPrefix)
+	if !found {
+		return "", "", false
+	}
+
+	id, secret, ok = strings.Cut(rest, "_")
+	return id, secret, ok && id != "" && secret != ""
+}
+
+// hash is a plain SHA-256: keys are 256-bit random values, so a slow
+// password hash would add latency without adding security
+func hash(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func unixOrNil(t *time.Time) any {
+	if t == nil {
+		return nil
+	}
+
+	return t.Unix()
+}
+
+func timeOrNil(v sql.NullInt64) *time.Time {
+	if !v.Valid {
+		return nil
+	}
+
+	t := time.Unix(v.Int64, 0).UTC()
+	return &t
+}
@@ -0,0 +1,218 @@
+package apikey
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lamlv2305/sentinel/operator"
+)
+
+// newTestStore opens a store in a temporary directory, on a clock the test
+// can move
+func newTestStore(t *testing.T) (*Store, *time.Time) {
+	t.Helper()
+
+	s, err := NewStore(filepath.Join(t.TempDir(), "keys.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = s.Close() })
+
+	now := time.Unix(1_700_000_000, 0)
+	s.now = func() time.Time { return now }
+
+	return s, &now
+}
+
+func TestStoreCreateAuthenticate(t *testing.T) {
+	ctx := context.Background()
+	s, _ := newTestStore(t)
+
+	secret, key, err := s.Create(ctx, CreateParams{Name: "ci", Projects: []string{"billing"}, Roles: []Role{RoleRead, RoleSecrets}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(secret, keyPrefix+key.Id+"_") {
+		t.Errorf("secret %q is not sk_<id>_<secret>", secret)
+	}
+
+	got, err := s.Authenticate(ctx, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Id != key.Id || !got.HasRole(RoleSecrets) || got.LastUsedAt != nil {
+		t.Errorf("Authenticate = %+v, want %s", got, key.Id)
+	}
+
+	stored, err := s.Get(ctx, key.Id)
+	if err != nil || stored.LastUsedAt == nil {
+		t.Errorf("Get = %+v, %v, want the use recorded", stored, err)
+	}
+
+	principal, err := s.Verify(ctx, secret, "billing")
+	if err != nil || principal.Subject != "apikey:"+key.Id || !principal.HasPermission(operator.PermissionSecrets) {
+		t.Errorf("Verify = %+v, %v", principal, err)
+	}
+	if _, err := s.Verify(ctx, secret, "payroll"); !errors.Is(err, ErrProjectDenied) {
+		t.Errorf("Verify on another project: error = %v, want %v", err, ErrProjectDenied)
+	}
+}
+
+func TestStoreAuthenticateInvalid(t *testing.T) {
+	ctx := context.Background()
+	s, _ := newTestStore(t)
+
+	secret, key, err := s.Create(ctx, CreateParams{Name: "ci", Projects: []string{"billing"}, Roles: []Role{RoleRead}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, apikey := range []string{
+		"",
+		key.Id,
+		"sk_" + key.Id,
+		"sk_" + key.Id + "_",
+		"sk__secret",
+		"pk_" + strings.TrimPrefix(secret, keyPrefix),
+		secret + "x",
+		"sk_0000000000000000_" + strings.SplitN(secret, "_", 3)[2],
+	} {
+		if _, err := s.Authenticate(ctx, apikey); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("Authenticate(%q): error = %v, want %v", apikey, err, ErrInvalidKey)
+		}
+	}
+}
+
+func TestStoreCreateValidation(t *testing.T) {
+	s, _ := newTestStore(t)
+
+	for _, params := range []CreateParams{
+		{Projects: []string{"billing"}, Roles: []Role{RoleRead}},
+		{Name: "ci", Roles: []Role{RoleRead}},
+		{Name: "ci", Projects: []string{"billing"}},
+		{Name: "ci", Projects: []string{"billing"}, Roles: []Role{"owner"}},
+	} {
+		if _, _, err := s.Create(context.Background(), params); err == nil {
+			t.Errorf("Create(%+v) succeeded", params)
+		}
+	}
+}
+
+func TestStoreExpiry(t *testing.T) {
+	ctx := context.Background()
+	s, now := newTestStore(t)
+
+	secret, key, err := s.Create(ctx, CreateParams{Name: "ci", Projects: []string{"billing"}, Roles: []Role{RoleRead}, TTL: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key.ExpiresAt == nil || !key.ExpiresAt.Equal(now.Add(time.Hour)) {
+		t.Fatalf("ExpiresAt = %v, want an hour from now", key.ExpiresAt)
+	}
+
+	*now = now.Add(time.Hour - time.Second)
+	if _, err := s.Authenticate(ctx, secret); err != nil {
+		t.Fatalf("before expiry: %v", err)
+	}
+
+	*now = now.Add(time.Second)
+	if _, err := s.Authenticate(ctx, secret); !errors.Is(err, ErrExpired) {
+		t.Fatalf("at expiry: error = %v, want %v", err, ErrExpired)
+	}
+}
+
+func TestStoreRevoke(t *testing.T) {
+	ctx := context.Background()
+	s, _ := newTestStore(t)
+
+	var revoked []string
+	s.OnRevoke(func(ctx context.Context, key Key) { revoked = append(revoked, key.Id) })
+
+	secret, key, err := s.Create(ctx, CreateParams{Name: "ci", Projects: []string{"billing"}, Roles: []Role{RoleRead}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for range 2 {
+		if err := s.Revoke(ctx, key.Id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(revoked) != 1 || revoked[0] != key.Id {
+		t.Errorf("OnRevoke ran for %v, want %s once", revoked, key.Id)
+	}
+
+	if _, err := s.Authenticate(ctx, secret); !errors.Is(err, ErrRevoked) {
+		t.Errorf("Authenticate revoked: error = %v, want %v", err, ErrRevoked)
+	}
+	if err := s.Revoke(ctx, "missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Revoke missing: error = %v, want %v", err, ErrNotFound)
+	}
+	if _, _, err := s.Rotate(ctx, key.Id, time.Hour); !errors.Is(err, ErrRevoked) {
+		t.Errorf("Rotate revoked: error = %v, want %v", err, ErrRevoked)
+	}
+}
+
+func TestStoreRotate(t *testing.T) {
+	ctx := context.Background()
+	s, now := newTestStore(t)
+
+	oldSecret, old, err := s.Create(ctx, CreateParams{Name: "ci", Projects: []string{"billing"}, Groups: []string{"config/**"}, Roles: []Role{RolePublish}, TTL: 48 * time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newSecret, key, err := s.Rotate(ctx, old.Id, DefaultRotateOverlap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key.Id == old.Id || key.Name != old.Name || !key.HasRole(RolePublish) || len(key.Groups) != 1 {
+		t.Errorf("rotated key = %+v, want the scope of %+v", key, old)
+	}
+	if key.ExpiresAt == nil || !key.ExpiresAt.Equal(now.Add(48*time.Hour)) {
+		t.Errorf("rotated key expires at %v, want the same TTL", key.ExpiresAt)
+	}
+
+	retired, err := s.Get(ctx, old.Id)
+	if err != nil || retired.RotatedTo != key.Id || !retired.ExpiresAt.Equal(now.Add(DefaultRotateOverlap)) {
+		t.Errorf("old key = %+v, %v, want rotated to %s for the overlap", retired, err, key.Id)
+	}
+
+	// Both keys work during the overlap, only the new one after it
+	for _, secret := range []string{oldSecret, newSecret} {
+		if _, err := s.Authenticate(ctx, secret); err != nil {
+			t.Errorf("during the overlap: %v", err)
+		}
+	}
+
+	*now = now.Add(DefaultRotateOverlap)
+	if _, err := s.Authenticate(ctx, oldSecret); !errors.Is(err, ErrExpired) {
+		t.Errorf("old key after the overlap: error = %v, want %v", err, ErrExpired)
+	}
+	if _, err := s.Authenticate(ctx, newSecret); err != nil {
+		t.Errorf("new key after the overlap: %v", err)
+	}
+}
+
+func TestStoreRotateKeepsEarlierExpiry(t *testing.T) {
+	ctx := context.Background()
+	s, now := newTestStore(t)
+
+	_, old, err := s.Create(ctx, CreateParams{Name: "ci", Projects: []string{"billing"}, Roles: []Role{RoleRead}, TTL: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := s.Rotate(ctx, old.Id, DefaultRotateOverlap); err != nil {
+		t.Fatal(err)
+	}
+
+	retired, err := s.Get(ctx, old.Id)
+	if err != nil || !retired.ExpiresAt.Equal(now.Add(time.Hour)) {
+		t.Errorf("old key expires at %v, want its own earlier expiry", retired.ExpiresAt)
+	}
+}
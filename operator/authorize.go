@@ -0,0 +1,75 @@
+package operator
+
+import (
+	"net/http"
+)
+
+// actionPermissions is how actions are authorized when no Policy is set
+var actionPermissions = map[Action]Permission{
+	ActionPublish:  PermissionPublish,
+	ActionSnapshot: PermissionPublish,
+	ActionRollback: PermissionPublish,
+	ActionAdmin:    PermissionAdmin,
+}
+
+// Authorize reports whether the principal may perform the action on a group
+// of a project; pass AnyGroup for project level actions. The principal's own
+// scope always applies. Beyond it, the Policy set with WithSSEPolicy decides;
+// without one, viewing and subscribing are allowed and other actions need
+// the matching principal permission.
+func (s *SSE) Authorize(principal *Principal, action Action, project, group string) bool {
+	if principal == nil || !principal.AllowsProject(project) {
+		return false
+	}
+
+	if group != AnyGroup && !principal.AllowsGroup(group) {
+		return false
+	}
+
+	if s.policy != nil {
+		return s.policy.Evaluate(principal, action, project, group)
+	}
+
+	permission, ok := actionPermissions[action]
+	return !ok || principal.HasPermission(permission)
+}
+
+// AuthorizeRequest authenticates a request like a connecting client and
+// checks the action on each of its projects. It returns the status to reject the
+// request with, or http.StatusOK.
+func (s *SSE) AuthorizeRequest(r *http.Request, action Action) (*Principal, int) {
+	auth, status := s.authenticate(r)
+	if status != http.StatusOK {
+		return nil, status
+	}
+
+	if !s.authorizeAll(auth.principal, action, auth.projects) {
+		return auth.principal, http.StatusForbidden
+	}
+
+	return auth.principal, http.StatusOK
+}
+
+// authorizeAll checks the action on every project, in any group
+func (s *SSE) authorizeAll(principal *Principal, action Action, projects []string) bool {
+	for _, project := range projects {
+		if !s.Authorize(principal, action, project, AnyGroup) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// RequireAction protects an endpoint, such as snapshot, rollback or admin
+// APIs, with the action it performs
+func (s *SSE) RequireAction(action Action, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, status := s.AuthorizeRequest(r, action); status != http.StatusOK {
+			http.Error(w, http.StatusText(status), status)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
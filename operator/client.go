@@ -2,25 +2,55 @@ package operator
 
 import (
 	"context"
+	"crypto/x509"
+	"log/slog"
+	"slices"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/lamlv2305/sentinel/types"
 )
 
 type Client struct {
 	Id        string
-	ProjectId string
+	ProjectId string   // the first of Projects
+	Projects  []string // projects the client is subscribed to
+	Principal *Principal
+
+	// Subscription narrows the events the client receives
+	Subscription Subscription
+
+	// Labels are declared by the client on connect, for targeted delivery
+	Labels map[string]string
+
+	// Metadata describes the agent, as sent by it on connect
+	Metadata types.AgentMetadata
 
-	ch   chan string
-	done chan struct{}
-	mu   sync.Mutex
+	ConnectedAt time.Time
+
+	lastSent atomic.Int64 // unix nanoseconds of the last event written
+
+	credential  string            // kept to re-validate long-lived connections
+	certificate *x509.Certificate // set instead of credential for mTLS clients
+	terminal    string            // control message written before the stream ends
+	written     cursor            // position of the stream, owned by its writer
+	selectable  map[string]string // labels and metadata, as seen by selectors
+
+	outbox *outbox
+	ch     chan string // set by GetChannel
+	done   chan struct{}
+	mu     sync.Mutex
 }
 
 func NewClient(id, projectId string) *Client {
 	return &Client{
-		Id:        id,
-		ProjectId: projectId,
-		ch:        make(chan string, 100), // Larger buffer to handle bursts
-		done:      make(chan struct{}),
+		Id:          id,
+		ProjectId:   projectId,
+		Projects:    []string{projectId},
+		ConnectedAt: time.Now(),
+		outbox:      newOutbox(100, SlowConsumerDisconnect),
+		done:        make(chan struct{}),
 	}
 }
 
@@ -35,10 +65,29 @@ func (c *Client) Close() {
 		return
 	default:
 		close(c.done)
-		close(c.ch)
 	}
 }
 
+// Terminate closes the client after a final control message, written
+// instead of any message still pending
+func (c *Client) Terminate(message string) {
+	c.mu.Lock()
+	if c.IsConnected() {
+		c.terminal = message
+	}
+	c.mu.Unlock()
+
+	c.Close()
+}
+
+// terminalMessage returns the message set by Terminate, if any
+func (c *Client) terminalMessage() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.terminal
+}
+
 // IsConnected checks if the client is still connected
 func (c *Client) IsConnected() bool {
 	select {
@@ -49,28 +98,138 @@ func (c *Client) IsConnected() bool {
 	}
 }
 
-// GetChannel returns the client's message channel for reading
+// HasProject reports whether the client is subscribed to the project
+func (c *Client) HasProject(projectId string) bool {
+	return slices.Contains(c.Projects, projectId)
+}
+
+// identity names the agent behind the connection so that it is recognized
+// when it reconnects: by hostname and app, or else by principal, or else by
+// connection id
+func (c *Client) identity() string {
+	switch {
+	case c.Metadata.Hostname != "":
+		return c.Metadata.Hostname + "/" + c.Metadata.App
+	case c.Principal != nil && c.Principal.Subject != "":
+		return c.Principal.Subject
+	}
+
+	return c.Id
+}
+
+// LogValue implements slog.LogValuer.
+func (c *Client) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("id", c.Id),
+		slog.Any("projects", c.Projects),
+		slog.Any("principal", c.Principal),
+		slog.String("app", c.Metadata.App),
+		slog.String("hostname", c.Metadata.Hostname),
+	)
+}
+
+// selectorLabels returns the labels selectors match the client by: its
+// declared labels, plus its metadata under the reserved agent. prefix
+func (c *Client) selectorLabels() map[string]string {
+	labels := make(map[string]string, len(c.Labels)+3+len(c.Metadata.Capabilities))
+	for key, value := range c.Labels {
+		labels[key] = value
+	}
+
+	if c.Metadata.Hostname != "" {
+		labels[LabelHostname] = c.Metadata.Hostname
+	}
+	if c.Metadata.Version != "" {
+		labels[LabelVersion] = c.Metadata.Version
+	}
+	if c.Metadata.App != "" {
+		labels[LabelApp] = c.Metadata.App
+	}
+	for _, capability := range c.Metadata.Capabilities {
+		labels[LabelCapabilityPrefix+capability] = "true"
+	}
+
+	return labels
+}
+
+// LastSent returns when the last event was written to the client, or the
+// zero time if none was
+func (c *Client) LastSent() time.Time {
+	if nanos := c.lastSent.Load(); nanos != 0 {
+		return time.Unix(0, nanos)
+	}
+
+	return time.Time{}
+}
+
+// accepts reports whether the client's principal may receive the event
+func (c *Client) accepts(event types.ChangedEvent) bool {
+	if !c.Principal.AllowsGroup(event.Resource.Group) {
+		return false
+	}
+
+	return !event.Resource.IsSecret() || c.Principal.HasPermission(PermissionSecrets)
+}
+
+// GetChannel returns a channel carrying the client's queued messages, for
+// clients served outside OnConnected. It takes the messages from the outbox,
+// so it must not be used on clients OnConnected writes to. The channel is
+// closed with the client.
+//
+// Deprecated: messages are queued in the client's outbox, written by
+// OnConnected.
 func (c *Client) GetChannel() chan string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.ch == nil {
+		c.ch = make(chan string)
+		go c.forward(c.ch)
+	}
+
 	return c.ch
 }
 
-// SendWithTimeout sends a message with a timeout
-func (c *Client) SendWithTimeout(message string, timeout time.Duration) error {
+// forward writes the queued messages to ch until the client is closed
+func (c *Client) forward(ch chan string) {
+	defer close(ch)
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-c.outbox.ready:
+			for _, msg := range c.outbox.take() {
+				select {
+				case ch <- msg.data:
+				case <-c.done:
+					return
+				}
+			}
+		}
+	}
+}
+
+// SendWithTimeout queues a message without blocking. It fails once the
+// client has been over capacity for longer than timeout, or reached the
+// outbox hard cap; it no longer waits for room.
+func (c *Client) SendWithTimeout(data string, timeout time.Duration) error {
 	if !c.IsConnected() {
 		return context.Canceled
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
-	defer cancel()
-
-	select {
-	case <-ctx.Done():
-		return ctx.Err()
+	if ok, _ := c.deliver(message{data: data}, timeout); !ok {
+		return context.DeadlineExceeded
+	}
 
-	case c.ch <- message:
-		return nil
+	return nil
+}
 
-	case <-c.done:
-		return context.Canceled
-	}
+// deliver queues the message without blocking. It reports false when the
+// client reached the outbox hard cap, or has been over capacity for longer
+// than timeout, and is to be dropped, and whether an older message was
+// dropped to make room.
+func (c *Client) deliver(msg message, timeout time.Duration) (ok, dropped bool) {
+	over, dropped, full := c.outbox.offer(msg)
+	return !full && over <= timeout, dropped
 }
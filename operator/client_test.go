@@ -0,0 +1,33 @@
+package operator
+
+import (
+	"testing"
+	"time"
+)
+
+func TestClientGetChannel(t *testing.T) {
+	c := NewClient("c1", "billing")
+
+	if err := c.SendWithTimeout("hello", time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case got := <-c.GetChannel():
+		if got != "hello" {
+			t.Fatalf("got %q, want %q", got, "hello")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("message not forwarded to the channel")
+	}
+
+	c.Close()
+	select {
+	case _, open := <-c.GetChannel():
+		if open {
+			t.Fatal("channel not closed with the client")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("channel not closed with the client")
+	}
+}
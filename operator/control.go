@@ -0,0 +1,19 @@
+package operator
+
+import (
+	"github.com/goccy/go-json"
+	"github.com/lamlv2305/sentinel/types"
+)
+
+// controlEvent is sent as plain JSON, unlike base64 encoded resource changes
+type controlEvent struct {
+	Type   types.ControlType `json:"type"`
+	Id     string            `json:"id,omitempty"`
+	Reason string            `json:"reason,omitempty"`
+}
+
+// controlMessage formats a control event as an SSE message of that event type
+func controlMessage(event controlEvent) string {
+	data, _ := json.Marshal(event)
+	return "event: " + string(event.Type) + "\ndata: " + string(data)
+}
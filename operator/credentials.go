@@ -0,0 +1,74 @@
+package operator
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/lamlv2305/sentinel/types"
+)
+
+// credentialSource extracts credentials from a connecting request
+type credentialSource struct {
+	apikeyHeader  string
+	projectHeader string
+	allowQuery    bool // accept apikey from the query string
+}
+
+func defaultCredentialSource() credentialSource {
+	return credentialSource{
+		apikeyHeader:  types.HeaderAPIKey,
+		projectHeader: types.HeaderProject,
+	}
+}
+
+// extract returns the credential and projects of the request. The credential
+// is read from the Authorization header (Bearer or ApiKey scheme), then from
+// the apikey header, then, only if allowed, from the apikey query parameter.
+// Projects are not secret and come from the project header or, failing that,
+// the project query parameter, each repeatable or comma separated. There is
+// always at least one project, possibly empty.
+func (cs credentialSource) extract(r *http.Request) (apikey string, projects []string) {
+	if scheme, value, ok := strings.Cut(r.Header.Get("Authorization"), " "); ok {
+		switch strings.ToLower(scheme) {
+		case "bearer", "apikey":
+			apikey = strings.TrimSpace(value)
+		}
+	}
+
+	if apikey == "" {
+		apikey = r.Header.Get(cs.apikeyHeader)
+	}
+
+	if apikey == "" && cs.allowQuery {
+		apikey = r.URL.Query().Get("apikey")
+	}
+
+	projects = queryList(r.Header.Values(cs.projectHeader))
+	if len(projects) == 0 {
+		projects = queryList(r.URL.Query()["project"])
+	}
+
+	return apikey, normalizeProjects(projects)
+}
+
+// normalizeProjects drops duplicate projects, keeping their order
+func normalizeProjects(projects []string) []string {
+	out := make([]string, 0, len(projects))
+	for _, project := range projects {
+		if !slices.Contains(out, project) {
+			out = append(out, project)
+		}
+	}
+
+	if len(out) == 0 {
+		out = append(out, "")
+	}
+
+	return out
+}
+
+// allowedHeaders lists the request headers browsers may send for CORS
+func (cs credentialSource) allowedHeaders() string {
+	return strings.Join([]string{"Authorization", "Cache-Control", "Last-Event-ID", types.HeaderCoalesce, types.HeaderLabels, types.HeaderAgent, cs.apikeyHeader, cs.projectHeader}, ", ")
+}
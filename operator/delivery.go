@@ -0,0 +1,140 @@
+package operator
+
+import (
+	"errors"
+	"maps"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/lamlv2305/sentinel/types"
+)
+
+var errJournalGap = errors.New("missed messages are no longer in the journal")
+
+// defaultSpillJournalSize is the journal size used by SlowConsumerSpill
+// unless WithSSEJournal sets one
+const defaultSpillJournalSize = 1024
+
+// WithSSEBufferSize sets how many messages a client can have queued
+func WithSSEBufferSize(size int) WithSSE {
+	return func(s *SSE) {
+		s.bufferSize = size
+	}
+}
+
+// WithSSESendTimeout sets how long the outbox of a client may stay over
+// capacity before it is disconnected, under the policies that let it grow
+func WithSSESendTimeout(timeout time.Duration) WithSSE {
+	return func(s *SSE) {
+		s.hub.sendTimeout = timeout
+	}
+}
+
+// WithSSESlowConsumerPolicy sets what happens to clients that cannot keep up
+func WithSSESlowConsumerPolicy(policy SlowConsumerPolicy) WithSSE {
+	return func(s *SSE) {
+		s.slowConsumer = policy
+	}
+}
+
+// WithSSEJournal keeps the latest size messages of every project, so that
+// clients reconnecting with Last-Event-ID get what they missed
+func WithSSEJournal(size int) WithSSE {
+	return func(s *SSE) {
+		s.hub.journal = newJournal(size)
+	}
+}
+
+// wantsCoalescing reports whether the client opted into coalescing, with
+// the coalesce header or query parameter
+func wantsCoalescing(r *http.Request) bool {
+	value := r.Header.Get(types.HeaderCoalesce)
+	if value == "" {
+		value = r.URL.Query().Get("coalesce")
+	}
+
+	coalesce, _ := strconv.ParseBool(value)
+	return coalesce
+}
+
+// startDelivery positions the client at the latest message of each of its
+// projects or, when it reconnects with a Last-Event-ID, where it left off,
+// replaying what it missed
+func (s *SSE) startDelivery(r *http.Request, client *Client) {
+	head := s.hub.journal.head(client.Projects)
+	client.written = maps.Clone(head)
+	replay := false
+
+	if id := r.Header.Get("Last-Event-ID"); id != "" {
+		if last, ok := s.hub.journal.parseId(id); ok {
+			for _, project := range client.Projects {
+				seq, ok := last[project]
+				if !ok || seq >= head[project] {
+					continue
+				}
+
+				if _, ok := s.hub.journal.since(project, seq+1); !ok {
+					s.hub.logger.Warn("Client resumes beyond the journal, some messages are lost", "client", client, "projectId", project)
+					continue
+				}
+
+				client.written[project] = seq
+				replay = true
+			}
+		}
+	}
+
+	next := make(cursor, len(client.written))
+	for project, seq := range client.written {
+		next[project] = seq + 1
+	}
+	client.outbox.start(next, replay)
+}
+
+// replay writes the journaled messages the client missed while its outbox
+// spilled, until it has caught up with live delivery
+func (s *SSE) replay(w http.ResponseWriter, client *Client, flusher http.Flusher) error {
+	for {
+		next, spilled := client.outbox.pending()
+		if !spilled {
+			return nil
+		}
+
+		for _, project := range client.Projects {
+			entries, ok := s.hub.journal.since(project, next[project])
+			if !ok {
+				return errJournalGap
+			}
+
+			for _, entry := range entries {
+				next[project] = entry.seq + 1
+				if !entry.target.matches(client) || !s.accepts(client, entry.event) {
+					client.written[project] = entry.seq
+					continue
+				}
+
+				msg := message{project: project, seq: entry.seq, data: entry.payload}
+				if err := s.writeMessage(w, client, msg, flusher); err != nil {
+					return err
+				}
+			}
+		}
+
+		if client.outbox.resume(next, s.hub.journal.last) {
+			return nil
+		}
+	}
+}
+
+// writeMessage writes a message, with an id encoding the position of the
+// client in all of its projects
+func (s *SSE) writeMessage(w http.ResponseWriter, client *Client, msg message, flusher http.Flusher) error {
+	client.lastSent.Store(time.Now().UnixNano())
+	if msg.seq == 0 {
+		return s.writeSSE(w, msg.data+"\n\n", flusher)
+	}
+
+	client.written[msg.project] = msg.seq
+	return s.writeSSE(w, "id: "+s.hub.journal.formatId(client.written)+"\n"+msg.data+"\n\n", flusher)
+}
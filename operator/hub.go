@@ -2,105 +2,366 @@ package operator
 
 import (
 	"log/slog"
+	"runtime"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/lamlv2305/sentinel/types"
 )
 
+// fanoutChunk is how many clients a single goroutine delivers to; larger
+// projects are fanned out in parallel chunks
+const fanoutChunk = 4096
+
 type hub struct {
-	mu      *sync.RWMutex
-	clients map[string]map[string]*Client
-	logger  *slog.Logger
+	mu       *sync.RWMutex
+	projects map[string]*shard
+	logger   *slog.Logger
+
+	journal     *journal
+	sendTimeout time.Duration
+}
+
+// shard holds the clients of one project. Broadcasts to the project are
+// serialized by the shard, so clients see events in journal order, and read
+// an immutable snapshot of its clients that is rebuilt only after clients
+// come or go. Fan-out takes no hub lock and allocates nothing per client.
+type shard struct {
+	dispatch sync.Mutex // serializes broadcasts to the project
+
+	mu       sync.Mutex // guards clients and labels
+	clients  map[string]*Client
+	labels   map[string]map[string]map[string]*Client // key, value, client id
+	snapshot atomic.Pointer[[]*Client]
+}
+
+func newShard() *shard {
+	return &shard{
+		clients: make(map[string]*Client),
+		labels:  make(map[string]map[string]map[string]*Client),
+	}
 }
 
 func defaultHub() *hub {
 	return &hub{
-		mu:      &sync.RWMutex{},
-		clients: make(map[string]map[string]*Client),
-		logger:  slog.Default(),
+		mu:          &sync.RWMutex{},
+		projects:    make(map[string]*shard),
+		logger:      slog.Default(),
+		journal:     newJournal(0),
+		sendTimeout: 5 * time.Second,
 	}
 }
 
+// members returns the current clients of the shard
+func (s *shard) members() []*Client {
+	if snapshot := s.snapshot.Load(); snapshot != nil {
+		return *snapshot
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	members := make([]*Client, 0, len(s.clients))
+	for _, client := range s.clients {
+		members = append(members, client)
+	}
+	s.snapshot.Store(&members)
+
+	return members
+}
+
+// targeted returns the clients the target may address: the connections it
+// names, or those the label index finds for one of its selector's equality
+// requirements. Every candidate still has to match the target's selector.
+func (s *shard) targeted(target Target) []*Client {
+	if len(target.ConnectionIds) == 0 {
+		key, value, ok := target.Selector.equality()
+		if !ok {
+			return s.members()
+		}
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		found := make([]*Client, 0, len(s.labels[key][value]))
+		for _, client := range s.labels[key][value] {
+			found = append(found, client)
+		}
+		return found
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var found []*Client
+	for _, id := range target.ConnectionIds {
+		if client, ok := s.clients[id]; ok {
+			found = append(found, client)
+		}
+	}
+
+	return found
+}
+
+// index adds the client to the label index, or removes it
+func (s *shard) index(c *Client, add bool) {
+	for key, value := range c.selectable {
+		values, ok := s.labels[key]
+		if !ok {
+			if !add {
+				continue
+			}
+			values = make(map[string]map[string]*Client)
+			s.labels[key] = values
+		}
+
+		clients, ok := values[value]
+		if !ok {
+			if !add {
+				continue
+			}
+			clients = make(map[string]*Client)
+			values[value] = clients
+		}
+
+		if add {
+			clients[c.Id] = c
+			continue
+		}
+
+		delete(clients, c.Id)
+		if len(clients) == 0 {
+			delete(values, value)
+		}
+		if len(values) == 0 {
+			delete(s.labels, key)
+		}
+	}
+}
+
+// add registers the client with each of its projects
 func (d *hub) add(c *Client) {
+	c.selectable = c.selectorLabels()
+
 	d.mu.Lock()
 	defer d.mu.Unlock()
 
-	if _, ok := d.clients[c.ProjectId]; !ok {
-		d.clients[c.ProjectId] = make(map[string]*Client)
-	}
+	for _, projectId := range c.Projects {
+		s, ok := d.projects[projectId]
+		if !ok {
+			s = newShard()
+			d.projects[projectId] = s
+		}
 
-	d.clients[c.ProjectId][c.Id] = c
+		s.mu.Lock()
+		s.clients[c.Id] = c
+		s.index(c, true)
+		s.snapshot.Store(nil)
+		s.mu.Unlock()
+	}
 }
 
-func (d *hub) remove(projectId, clientId string) {
+// remove closes the client and unregisters it from each of its projects
+func (d *hub) remove(c *Client) {
+	c.Close()
+
 	d.mu.Lock()
 	defer d.mu.Unlock()
 
-	clients, ok := d.clients[projectId]
-	if !ok {
-		return
-	}
+	for _, projectId := range c.Projects {
+		s, ok := d.projects[projectId]
+		if !ok {
+			continue
+		}
+
+		s.mu.Lock()
+		if _, exists := s.clients[c.Id]; exists {
+			delete(s.clients, c.Id)
+			s.index(c, false)
+			s.snapshot.Store(nil)
+		}
+		empty := len(s.clients) == 0
+		s.mu.Unlock()
 
-	client, exists := clients[clientId]
-	if !exists {
-		return
+		if empty {
+			delete(d.projects, projectId)
+		}
 	}
+}
 
-	client.Close()
-	delete(clients, clientId)
+// delivery is the outcome of a broadcast
+type delivery struct {
+	report     DeliveryReport
+	recipients []*Client // clients the message was queued to, when collected
+}
 
-	if len(clients) == 0 {
-		delete(d.clients, projectId)
-	}
+func (d *delivery) merge(other delivery) {
+	d.report.Targeted += other.report.Targeted
+	d.report.Enqueued += other.report.Enqueued
+	d.report.Dropped += other.report.Dropped
+	d.report.Disconnected += other.report.Disconnected
+	d.recipients = append(d.recipients, other.recipients...)
 }
 
-func (d *hub) broadcast(projectId string, message string) {
+// broadcast records the event in the journal and sends it, as payload, to
+// the clients of its project addressed by the target and accepted by the
+// filter; a nil filter accepts all clients. Sending never blocks: clients
+// that stay over capacity for longer than the send timeout are removed. With
+// collect, the delivery lists the clients the message was queued to.
+func (d *hub) broadcast(event types.ChangedEvent, payload string, target Target, filter func(*Client) bool, collect bool) delivery {
+	projectId := event.Resource.ProjectId
+
 	d.mu.RLock()
-	clients, ok := d.clients[projectId]
+	s, ok := d.projects[projectId]
+	d.mu.RUnlock()
+
 	if !ok {
-		d.mu.RUnlock()
-		return
+		d.journal.append(event, target, payload)
+		return delivery{}
+	}
+
+	s.dispatch.Lock()
+	defer s.dispatch.Unlock()
+
+	entry := d.journal.append(event, target, payload)
+	msg := message{
+		project: projectId,
+		seq:     entry.seq,
+		key:     projectId + "/" + event.Resource.Id(),
+		data:    entry.payload,
+	}
+
+	clients := s.members()
+	if !target.IsZero() {
+		// Candidates are already among the target's connections, if it names
+		// any, so only the selector is left to check per client
+		clients = s.targeted(target)
+		accepts := filter
+		filter = func(c *Client) bool {
+			return target.Selector.Matches(c.selectable) && (accepts == nil || accepts(c))
+		}
 	}
 
-	// Send messages concurrently without copying the entire client list
+	if len(clients) <= fanoutChunk {
+		return d.deliver(clients, msg, filter, collect)
+	}
+
+	// Fan large projects out over the available CPUs
+	workers := min(runtime.GOMAXPROCS(0), (len(clients)+fanoutChunk-1)/fanoutChunk)
+	size := (len(clients) + workers - 1) / workers
+	chunks := make([]delivery, workers)
+
 	var wg sync.WaitGroup
-	for _, client := range clients {
+	for i, start := 0, 0; start < len(clients); i, start = i+1, start+size {
+		end := min(start+size, len(clients))
+
 		wg.Add(1)
-		go func(c *Client) {
+		go func(i int, chunk []*Client) {
 			defer wg.Done()
-			if err := c.SendWithTimeout(message, 5*time.Second); err != nil {
-				d.logger.Warn("Client send failed, removing client",
-					"projectId", projectId,
-					"clientId", c.Id,
-					"error", err)
-				// Remove client in a separate goroutine to avoid deadlock
-				go d.remove(projectId, c.Id)
-			}
-		}(client)
+			chunks[i] = d.deliver(chunk, msg, filter, collect)
+		}(i, clients[start:end])
 	}
-	d.mu.RUnlock()
 	wg.Wait()
+
+	var result delivery
+	for _, chunk := range chunks {
+		result.merge(chunk)
+	}
+
+	return result
 }
 
-// healthCheck performs a health check on all clients and removes dead ones
-func (d *hub) cleanup() {
+func (d *hub) deliver(clients []*Client, msg message, filter func(*Client) bool, collect bool) delivery {
+	var result delivery
+	for _, c := range clients {
+		if filter != nil && !filter(c) {
+			continue
+		}
+		result.report.Targeted++
+
+		ok, dropped := c.deliver(msg, d.sendTimeout)
+		if !ok {
+			d.evict(c)
+			result.report.Disconnected++
+			continue
+		}
+
+		result.report.Enqueued++
+		if dropped {
+			result.report.Dropped++
+		}
+		if collect {
+			result.recipients = append(result.recipients, c)
+		}
+	}
+
+	return result
+}
+
+// evictSlow removes the clients over capacity for longer than the send
+// timeout, for projects no broadcast reached since they fell behind
+func (d *hub) evictSlow() {
+	for _, c := range d.find(func(c *Client) bool { return c.outbox.overFor() > d.sendTimeout }) {
+		d.evict(c)
+	}
+}
+
+func (d *hub) evict(c *Client) {
+	d.logger.Warn("Client too slow, removing client", "client", c)
+	d.remove(c)
+}
+
+// find returns the clients of every project accepted by the filter, once
+// each
+func (d *hub) find(filter func(*Client) bool) []*Client {
 	d.mu.RLock()
-	var disconnectedClients []struct{ projectId, clientId string }
+	shards := make([]*shard, 0, len(d.projects))
+	for _, s := range d.projects {
+		shards = append(shards, s)
+	}
+	d.mu.RUnlock()
+
+	var found []*Client
+	seen := make(map[*Client]struct{})
+	for _, s := range shards {
+		for _, client := range s.members() {
+			if _, ok := seen[client]; ok {
+				continue
+			}
+			seen[client] = struct{}{}
 
-	for projectId, clients := range d.clients {
-		for clientId, client := range clients {
-			if !client.IsConnected() {
-				disconnectedClients = append(disconnectedClients,
-					struct{ projectId, clientId string }{projectId: projectId, clientId: clientId})
+			if filter(client) {
+				found = append(found, client)
 			}
 		}
 	}
-	d.mu.RUnlock()
+
+	return found
+}
+
+// counts returns how many clients each project has
+func (d *hub) counts() map[string]int {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	counts := make(map[string]int, len(d.projects))
+	for projectId, s := range d.projects {
+		s.mu.Lock()
+		counts[projectId] = len(s.clients)
+		s.mu.Unlock()
+	}
+
+	return counts
+}
+
+// healthCheck performs a health check on all clients and removes dead ones
+func (d *hub) cleanup() {
+	disconnectedClients := d.find(func(c *Client) bool { return !c.IsConnected() })
 
 	// Remove disconnected clients
 	for _, dc := range disconnectedClients {
-		d.logger.Info("Removing disconnected client",
-			"projectId", dc.projectId,
-			"clientId", dc.clientId)
-		d.remove(dc.projectId, dc.clientId)
+		d.logger.Info("Removing disconnected client", "client", dc)
+		d.remove(dc)
 	}
 }
@@ -0,0 +1,86 @@
+package operator
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"testing"
+
+	"github.com/lamlv2305/sentinel/types"
+)
+
+// BenchmarkHubBroadcast measures the fan-out of one event to every client of
+// a project. Clients drop their oldest message when full and are never read,
+// so the benchmark covers queueing into full outboxes only.
+func BenchmarkHubBroadcast(b *testing.B) {
+	for _, clients := range []int{10_000, 100_000} {
+		b.Run(strconv.Itoa(clients/1000)+"k", func(b *testing.B) {
+			d := defaultHub()
+			for i := range clients {
+				c := NewClient(strconv.Itoa(i), "bench")
+				c.outbox = newOutbox(100, SlowConsumerDropOldest)
+				d.add(c)
+			}
+
+			event := types.ChangedEvent{Resource: types.Resource{ProjectId: "bench", ResourceId: "config"}}
+
+			// Fill the outboxes so every iteration drops the oldest message
+			for range 100 {
+				d.broadcast(event, "data", Target{}, nil, false)
+			}
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for range b.N {
+				d.broadcast(event, "data", Target{}, nil, false)
+			}
+		})
+	}
+}
+
+func TestDeliveryReport(t *testing.T) {
+	s := NewSSE(http.NewServeMux(), "/sse")
+
+	newClient := func(id string, outbox *outbox) *Client {
+		client := NewClient(id, "billing")
+		client.Principal = &Principal{Subject: id}
+		if outbox != nil {
+			client.outbox = outbox
+		}
+		s.hub.add(client)
+		return client
+	}
+
+	newClient("ready", nil)
+	full := newClient("full", newOutbox(1, SlowConsumerDropOldest))
+	stuck := newClient("stuck", newOutbox(1, SlowConsumerDisconnect))
+	newClient("other-group", nil).Subscription = Subscription{Groups: []string{"payments"}}
+
+	event := types.ChangedEvent{Resource: types.Resource{ProjectId: "billing", ResourceId: "config", Group: "config"}}
+	if _, err := s.Broadcast(context.Background(), event); err != nil {
+		t.Fatal(err)
+	}
+	// Fill the stuck client up to its hard cap
+	stuck.outbox.offer(message{data: "filler"})
+
+	report, err := s.Broadcast(context.Background(), event)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := DeliveryReport{Version: report.Version, Targeted: 3, Enqueued: 2, Dropped: 1, Disconnected: 1}
+	if report != want {
+		t.Errorf("report = %+v, want %+v", report, want)
+	}
+	if report.Version == "" {
+		t.Error("report without a version")
+	}
+	if full.outbox.fill() != 1 || stuck.IsConnected() {
+		t.Errorf("full client queues %d, stuck client connected %v", full.outbox.fill(), stuck.IsConnected())
+	}
+
+	empty, err := s.Broadcast(context.Background(), types.ChangedEvent{Resource: types.Resource{ProjectId: "payroll", ResourceId: "config"}})
+	if err != nil || empty.Targeted != 0 || empty.Enqueued != 0 {
+		t.Errorf("report of a project without clients = %+v, %v", empty, err)
+	}
+}
@@ -0,0 +1,156 @@
+package operator
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lamlv2305/sentinel/types"
+)
+
+// journalEntry is a broadcast event and the payload written to clients
+type journalEntry struct {
+	seq     uint64
+	event   types.ChangedEvent
+	target  Target
+	payload string
+}
+
+// journal numbers the messages of every project and keeps the latest ones,
+// so clients that fell behind or reconnect can catch up. Message ids are
+// prefixed with an epoch unique to the process, so ids from before a
+// restart are never mistaken for current ones.
+type journal struct {
+	size  int
+	epoch string
+
+	mu       sync.Mutex
+	projects map[string]*projectJournal
+}
+
+// projectJournal keeps the latest entries of a project in a ring buffer
+type projectJournal struct {
+	last    uint64
+	entries []journalEntry // grows up to the journal size, then wraps
+	oldest  int            // index of the oldest entry once full
+}
+
+// cursor is the position of a client in each of its projects: the sequence
+// of the last message written, or to skip
+type cursor map[string]uint64
+
+func newJournal(size int) *journal {
+	return &journal{
+		size:     size,
+		epoch:    strconv.FormatInt(time.Now().UnixNano(), 36),
+		projects: make(map[string]*projectJournal),
+	}
+}
+
+// append numbers the event and records it; payload is the event's data line
+func (j *journal) append(event types.ChangedEvent, target Target, payload string) journalEntry {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	p, ok := j.projects[event.Resource.ProjectId]
+	if !ok {
+		p = &projectJournal{}
+		j.projects[event.Resource.ProjectId] = p
+	}
+
+	p.last++
+	entry := journalEntry{seq: p.last, event: event, target: target, payload: payload}
+
+	if j.size > 0 {
+		if len(p.entries) < j.size {
+			p.entries = append(p.entries, entry)
+		} else {
+			p.entries[p.oldest] = entry
+			p.oldest = (p.oldest + 1) % len(p.entries)
+		}
+	}
+
+	return entry
+}
+
+// since returns the entries of the project from seq on. It reports false
+// when some of them are no longer kept.
+func (j *journal) since(projectId string, seq uint64) ([]journalEntry, bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	p, ok := j.projects[projectId]
+	if !ok || seq > p.last {
+		return nil, true
+	}
+
+	if len(p.entries) == 0 || p.entries[p.oldest].seq > seq {
+		return nil, false
+	}
+
+	skip := int(seq - p.entries[p.oldest].seq)
+	entries := make([]journalEntry, 0, len(p.entries)-skip)
+	for i := skip; i < len(p.entries); i++ {
+		entries = append(entries, p.entries[(p.oldest+i)%len(p.entries)])
+	}
+
+	return entries, true
+}
+
+// last returns the sequence of the latest message of the project
+func (j *journal) last(projectId string) uint64 {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if p, ok := j.projects[projectId]; ok {
+		return p.last
+	}
+
+	return 0
+}
+
+// head returns a cursor at the latest message of every project
+func (j *journal) head(projects []string) cursor {
+	c := make(cursor, len(projects))
+	for _, project := range projects {
+		c[project] = j.last(project)
+	}
+
+	return c
+}
+
+// formatId encodes a cursor as an SSE message id
+func (j *journal) formatId(c cursor) string {
+	values := make(url.Values, len(c))
+	for project, seq := range c {
+		values.Set(project, strconv.FormatUint(seq, 10))
+	}
+
+	return j.epoch + "." + values.Encode()
+}
+
+// parseId decodes a message id written by this journal
+func (j *journal) parseId(id string) (cursor, bool) {
+	epoch, encoded, ok := strings.Cut(id, ".")
+	if !ok || epoch != j.epoch {
+		return nil, false
+	}
+
+	values, err := url.ParseQuery(encoded)
+	if err != nil {
+		return nil, false
+	}
+
+	c := make(cursor, len(values))
+	for project := range values {
+		seq, err := strconv.ParseUint(values.Get(project), 10, 64)
+		if err != nil {
+			return nil, false
+		}
+		c[project] = seq
+	}
+
+	return c, true
+}
@@ -0,0 +1,46 @@
+package operator
+
+import (
+	"testing"
+
+	"github.com/lamlv2305/sentinel/types"
+)
+
+func TestJournalSince(t *testing.T) {
+	j := newJournal(3)
+	for range 5 {
+		j.append(types.ChangedEvent{Resource: types.Resource{ProjectId: "billing"}}, Target{}, "")
+	}
+
+	tests := []struct {
+		seq  uint64
+		want []uint64
+		ok   bool
+	}{
+		{seq: 1, ok: false},
+		{seq: 2, ok: false},
+		{seq: 3, want: []uint64{3, 4, 5}, ok: true},
+		{seq: 5, want: []uint64{5}, ok: true},
+		{seq: 6, ok: true},
+	}
+
+	for _, tt := range tests {
+		entries, ok := j.since("billing", tt.seq)
+		if ok != tt.ok {
+			t.Fatalf("since(%d) ok = %v, want %v", tt.seq, ok, tt.ok)
+		}
+
+		var seqs []uint64
+		for _, entry := range entries {
+			seqs = append(seqs, entry.seq)
+		}
+		if len(seqs) != len(tt.want) {
+			t.Fatalf("since(%d) = %v, want %v", tt.seq, seqs, tt.want)
+		}
+		for i := range seqs {
+			if seqs[i] != tt.want[i] {
+				t.Fatalf("since(%d) = %v, want %v", tt.seq, seqs, tt.want)
+			}
+		}
+	}
+}
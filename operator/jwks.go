@@ -0,0 +1,135 @@
+package operator
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/goccy/go-json"
+)
+
+// JWKS is a set of verification keys indexed by kid
+type JWKS struct {
+	keys map[string]any
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg,omitempty"`
+	Use string `json:"use,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	K   string `json:"k,omitempty"` // oct
+	N   string `json:"n,omitempty"` // RSA
+	E   string `json:"e,omitempty"` // RSA
+	X   string `json:"x,omitempty"` // OKP
+}
+
+// LoadJWKS reads a JWKS from a file path or an http(s) URL
+func LoadJWKS(ctx context.Context, source string) (*JWKS, error) {
+	var data []byte
+	var err error
+
+	if strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://") {
+		data, err = fetchJWKS(ctx, source)
+	} else {
+		data, err = os.ReadFile(source)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load jwks: %w", err)
+	}
+
+	return ParseJWKS(data)
+}
+
+// ParseJWKS decodes oct (HS256), RSA (RS256) and OKP Ed25519 (EdDSA) keys;
+// keys of other types and encryption keys are skipped
+func ParseJWKS(data []byte) (*JWKS, error) {
+	var doc struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("failed to decode jwks: %w", err)
+	}
+
+	set := &JWKS{keys: make(map[string]any)}
+	for _, k := range doc.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+
+		key, err := k.decode()
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode jwk %q: %w", k.Kid, err)
+		}
+		if key != nil {
+			set.keys[k.Kid] = key
+		}
+	}
+
+	return set, nil
+}
+
+func (k jwk) decode() (any, error) {
+	b64 := base64.RawURLEncoding
+
+	switch k.Kty {
+	case "oct":
+		return b64.DecodeString(k.K)
+
+	case "RSA":
+		n, err := b64.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := b64.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}, nil
+
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, nil
+		}
+		x, err := b64.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid Ed25519 key size %d", len(x))
+		}
+		return ed25519.PublicKey(x), nil
+	}
+
+	return nil, nil
+}
+
+func fetchJWKS(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	return io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+}
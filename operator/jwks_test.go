@@ -0,0 +1,122 @@
+package operator
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/goccy/go-json"
+)
+
+func TestLoadJWKS(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edPublic, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret := []byte("shared-secret")
+
+	b64 := base64.RawURLEncoding
+	data, err := json.Marshal(map[string]any{"keys": []jwk{
+		{Kty: "oct", Kid: "hs", K: b64.EncodeToString(secret)},
+		{Kty: "RSA", Kid: "rs", Use: "sig", N: b64.EncodeToString(rsaKey.N.Bytes()), E: b64.EncodeToString(big.NewInt(int64(rsaKey.E)).Bytes())},
+		{Kty: "OKP", Kid: "ed", Crv: "Ed25519", X: b64.EncodeToString(edPublic)},
+		{Kty: "RSA", Kid: "enc", Use: "enc", N: "AQAB", E: "AQAB"},
+		{Kty: "OKP", Kid: "x25519", Crv: "X25519", X: b64.EncodeToString(edPublic)},
+		{Kty: "EC", Kid: "ec", Crv: "P-256"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(data)
+	}))
+	defer server.Close()
+
+	for _, source := range []string{path, server.URL} {
+		set, err := LoadJWKS(context.Background(), source)
+		if err != nil {
+			t.Fatalf("LoadJWKS(%s): %v", source, err)
+		}
+		if len(set.keys) != 3 {
+			t.Errorf("LoadJWKS(%s) kept %d keys, want the 3 signing keys", source, len(set.keys))
+		}
+
+		v := NewJWTVerifier(WithJWTKeySet(set))
+		for kid, key := range map[string]any{"hs": secret, "rs": rsaKey, "ed": edKey} {
+			alg := map[string]string{"hs": "HS256", "rs": "RS256", "ed": "EdDSA"}[kid]
+			token := signJWT(t, jwtHeader{Alg: alg, Kid: kid}, map[string]any{
+				"sub":      "svc",
+				"exp":      time.Now().Add(time.Hour).Unix(),
+				"projects": []string{"billing"},
+			}, key)
+
+			if _, err := v.Verify(context.Background(), token, "billing"); err != nil {
+				t.Errorf("%s: token signed by %s: %v", source, kid, err)
+			}
+		}
+	}
+}
+
+func TestJWKSErrors(t *testing.T) {
+	if _, err := ParseJWKS([]byte("{not json")); err == nil {
+		t.Error("ParseJWKS accepted malformed json")
+	}
+
+	short := `{"keys":[{"kty":"OKP","kid":"ed","crv":"Ed25519","x":"AAAA"}]}`
+	if _, err := ParseJWKS([]byte(short)); err == nil {
+		t.Error("ParseJWKS accepted a short Ed25519 key")
+	}
+
+	if _, err := LoadJWKS(context.Background(), filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("LoadJWKS read a missing file")
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	if _, err := LoadJWKS(context.Background(), server.URL); err == nil {
+		t.Error("LoadJWKS accepted a 404")
+	}
+}
+
+func TestJWTVerifierSetKeySet(t *testing.T) {
+	old := []byte("old-secret")
+	v := NewJWTVerifier(WithJWTHMACKey("old", old))
+
+	rotated, err := ParseJWKS([]byte(`{"keys":[{"kty":"oct","kid":"new","k":"` + base64.RawURLEncoding.EncodeToString([]byte("new-secret")) + `"}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	v.SetKeySet(rotated)
+
+	token := signJWT(t, jwtHeader{Alg: "HS256", Kid: "old"}, map[string]any{
+		"sub":      "svc",
+		"exp":      time.Now().Add(time.Hour).Unix(),
+		"projects": []string{"billing"},
+	}, old)
+	if _, err := v.Verify(context.Background(), token, "billing"); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("token of a replaced key: error = %v, want %v", err, ErrUnknownKey)
+	}
+}
@@ -0,0 +1,314 @@
+package operator
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/goccy/go-json"
+)
+
+var (
+	ErrInvalidToken   = errors.New("jwt: invalid token")
+	ErrTokenExpired   = errors.New("jwt: token expired")
+	ErrUnknownKey     = errors.New("jwt: unknown signing key")
+	ErrProjectDenied  = errors.New("jwt: project not allowed")
+	ErrInvalidClaims  = errors.New("jwt: invalid claims")
+	ErrUnsupportedAlg = errors.New("jwt: unsupported algorithm")
+)
+
+// JWTClaims are the registered claims the verifier checks plus the scope
+// claims it maps into a Principal
+type JWTClaims struct {
+	Subject   string   `json:"sub"`
+	Issuer    string   `json:"iss,omitempty"`
+	Audience  audience `json:"aud,omitempty"`
+	ExpiresAt int64    `json:"exp,omitempty"`
+	NotBefore int64    `json:"nbf,omitempty"`
+	IssuedAt  int64    `json:"iat,omitempty"`
+
+	Projects    []string          `json:"projects,omitempty"`
+	Groups      []string          `json:"groups,omitempty"`
+	Permissions []Permission      `json:"permissions,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+
+	// KeyId is the kid of the key that signed the token
+	KeyId string `json:"-"`
+}
+
+// audience accepts both the string and the array form of the aud claim
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+
+	var many []string
+	if err := json.Unmarshal(data, &many); err != nil {
+		return err
+	}
+	*a = many
+
+	return nil
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid,omitempty"`
+	Typ string `json:"typ,omitempty"`
+}
+
+// JWTVerifier verifies HS256, RS256 and EdDSA signed tokens and maps their
+// claims into a Principal. Its Verify method is a CredentialVerifier.
+type JWTVerifier struct {
+	issuer   string
+	audience string
+	leeway   time.Duration
+	now      func() time.Time
+
+	mu   sync.RWMutex
+	keys map[string]any // kid -> []byte, *rsa.PublicKey or ed25519.PublicKey
+}
+
+type JWTOption func(*JWTVerifier)
+
+// WithJWTIssuer requires the iss claim to match
+func WithJWTIssuer(issuer string) JWTOption {
+	return func(v *JWTVerifier) {
+		v.issuer = issuer
+	}
+}
+
+// WithJWTAudience requires the aud claim to contain the audience
+func WithJWTAudience(audience string) JWTOption {
+	return func(v *JWTVerifier) {
+		v.audience = audience
+	}
+}
+
+// WithJWTLeeway tolerates clock skew when checking exp and nbf
+func WithJWTLeeway(leeway time.Duration) JWTOption {
+	return func(v *JWTVerifier) {
+		v.leeway = leeway
+	}
+}
+
+// WithJWTHMACKey trusts an HS256 shared secret
+func WithJWTHMACKey(kid string, secret []byte) JWTOption {
+	return func(v *JWTVerifier) {
+		v.keys[kid] = secret
+	}
+}
+
+// WithJWTPublicKey trusts an RS256 (*rsa.PublicKey) or EdDSA
+// (ed25519.PublicKey) verification key
+func WithJWTPublicKey(kid string, key crypto.PublicKey) JWTOption {
+	return func(v *JWTVerifier) {
+		v.keys[kid] = key
+	}
+}
+
+// WithJWTKeySet trusts every key of a JWKS
+func WithJWTKeySet(set *JWKS) JWTOption {
+	return func(v *JWTVerifier) {
+		for kid, key := range set.keys {
+			v.keys[kid] = key
+		}
+	}
+}
+
+func NewJWTVerifier(opts ...JWTOption) *JWTVerifier {
+	v := &JWTVerifier{
+		leeway: 30 * time.Second,
+		now:    time.Now,
+		keys:   make(map[string]any),
+	}
+
+	for _, opt := range opts {
+		opt(v)
+	}
+
+	return v
+}
+
+// SetKeySet replaces the trusted keys, e.g. after reloading a rotated JWKS
+func (v *JWTVerifier) SetKeySet(set *JWKS) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	v.keys = make(map[string]any, len(set.keys))
+	for kid, key := range set.keys {
+		v.keys[kid] = key
+	}
+}
+
+// Verify implements CredentialVerifier.
+func (v *JWTVerifier) Verify(ctx context.Context, token string, project string) (*Principal, error) {
+	claims, err := v.Parse(token)
+	if err != nil {
+		return nil, err
+	}
+
+	principal := &Principal{
+		Subject:     claims.Subject,
+		KeyId:       claims.KeyId,
+		Labels:      claims.Labels,
+		Projects:    claims.Projects,
+		Groups:      claims.Groups,
+		Permissions: claims.Permissions,
+	}
+
+	// Tokens must name the projects they grant
+	if len(claims.Projects) == 0 || !principal.AllowsProject(project) {
+		return nil, ErrProjectDenied
+	}
+
+	return principal, nil
+}
+
+// Parse checks the signature and the registered claims of a token
+func (v *JWTVerifier) Parse(token string) (*JWTClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidToken
+	}
+
+	var header jwtHeader
+	var claims JWTClaims
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	key, err := v.key(header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	claims.KeyId = header.Kid
+
+	if err := verifySignature(header.Alg, key, []byte(parts[0]+"."+parts[1]), signature); err != nil {
+		return nil, err
+	}
+
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, ErrInvalidClaims
+	}
+
+	if err := v.validate(&claims); err != nil {
+		return nil, err
+	}
+
+	return &claims, nil
+}
+
+func (v *JWTVerifier) key(kid string) (any, error) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	if key, ok := v.keys[kid]; ok {
+		return key, nil
+	}
+
+	// Tokens without kid are accepted when a single key is trusted
+	if kid == "" && len(v.keys) == 1 {
+		for _, key := range v.keys {
+			return key, nil
+		}
+	}
+
+	return nil, ErrUnknownKey
+}
+
+func (v *JWTVerifier) validate(claims *JWTClaims) error {
+	now := v.now()
+
+	if claims.ExpiresAt == 0 {
+		return fmt.Errorf("%w: missing exp", ErrInvalidClaims)
+	}
+
+	if now.After(time.Unix(claims.ExpiresAt, 0).Add(v.leeway)) {
+		return ErrTokenExpired
+	}
+
+	if claims.NotBefore != 0 && now.Add(v.leeway).Before(time.Unix(claims.NotBefore, 0)) {
+		return fmt.Errorf("%w: token not valid yet", ErrInvalidClaims)
+	}
+
+	if v.issuer != "" && claims.Issuer != v.issuer {
+		return fmt.Errorf("%w: unexpected issuer", ErrInvalidClaims)
+	}
+
+	if v.audience != "" && !slices.Contains(claims.Audience, v.audience) {
+		return fmt.Errorf("%w: unexpected audience", ErrInvalidClaims)
+	}
+
+	if claims.Subject == "" {
+		return fmt.Errorf("%w: missing sub", ErrInvalidClaims)
+	}
+
+	return nil
+}
+
+// verifySignature checks the signature with the algorithm the key type
+// dictates, so a token cannot pick a weaker algorithm for a key
+func verifySignature(alg string, key any, signed, signature []byte) error {
+	switch k := key.(type) {
+	case []byte:
+		if alg != "HS256" {
+			return ErrUnsupportedAlg
+		}
+		mac := hmac.New(sha256.New, k)
+		mac.Write(signed)
+		if !hmac.Equal(mac.Sum(nil), signature) {
+			return ErrInvalidToken
+		}
+
+	case *rsa.PublicKey:
+		if alg != "RS256" {
+			return ErrUnsupportedAlg
+		}
+		digest := sha256.Sum256(signed)
+		if rsa.VerifyPKCS1v15(k, crypto.SHA256, digest[:], signature) != nil {
+			return ErrInvalidToken
+		}
+
+	case ed25519.PublicKey:
+		if alg != "EdDSA" {
+			return ErrUnsupportedAlg
+		}
+		if !ed25519.Verify(k, signed, signature) {
+			return ErrInvalidToken
+		}
+
+	default:
+		return ErrUnsupportedAlg
+	}
+
+	return nil
+}
+
+func decodeSegment(segment string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, v)
+}
@@ -0,0 +1,163 @@
+package operator
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/goccy/go-json"
+)
+
+// signJWT builds a token with the given header and claims, signed by key for
+// the algorithm: a []byte HMAC secret, an *rsa.PrivateKey or an
+// ed25519.PrivateKey; other keys leave the signature empty
+func signJWT(t *testing.T, header jwtHeader, claims map[string]any, key any) string {
+	t.Helper()
+
+	encode := func(v any) string {
+		data, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(data)
+	}
+
+	signed := encode(header) + "." + encode(claims)
+
+	var signature []byte
+	switch k := key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, k)
+		mac.Write([]byte(signed))
+		signature = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		digest := sha256.Sum256([]byte(signed))
+		var err error
+		if signature, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:]); err != nil {
+			t.Fatal(err)
+		}
+	case ed25519.PrivateKey:
+		signature = ed25519.Sign(k, []byte(signed))
+	}
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestJWTVerifier(t *testing.T) {
+	secret := []byte("shared-secret")
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edPublic, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaDER, err := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Unix(1_700_000_000, 0)
+	v := NewJWTVerifier(
+		WithJWTIssuer("https://issuer"),
+		WithJWTAudience("sentinel"),
+		WithJWTLeeway(time.Minute),
+		WithJWTHMACKey("hs", secret),
+		WithJWTPublicKey("rs", &rsaKey.PublicKey),
+		WithJWTPublicKey("ed", edPublic),
+	)
+	v.now = func() time.Time { return now }
+
+	claims := func(overrides map[string]any) map[string]any {
+		c := map[string]any{
+			"sub":      "svc",
+			"iss":      "https://issuer",
+			"aud":      "sentinel",
+			"exp":      now.Add(time.Hour).Unix(),
+			"projects": []string{"billing"},
+		}
+		for name, value := range overrides {
+			if value == nil {
+				delete(c, name)
+				continue
+			}
+			c[name] = value
+		}
+		return c
+	}
+
+	tests := []struct {
+		name   string
+		header jwtHeader
+		claims map[string]any
+		key    any
+		err    error
+	}{
+		{name: "HS256", header: jwtHeader{Alg: "HS256", Kid: "hs"}, claims: claims(nil), key: secret},
+		{name: "RS256", header: jwtHeader{Alg: "RS256", Kid: "rs"}, claims: claims(nil), key: rsaKey},
+		{name: "EdDSA", header: jwtHeader{Alg: "EdDSA", Kid: "ed"}, claims: claims(nil), key: edKey},
+		{name: "audience array", header: jwtHeader{Alg: "EdDSA", Kid: "ed"}, claims: claims(map[string]any{"aud": []string{"other", "sentinel"}}), key: edKey},
+		{name: "wrong secret", header: jwtHeader{Alg: "HS256", Kid: "hs"}, claims: claims(nil), key: []byte("guess"), err: ErrInvalidToken},
+		{name: "HS256 signed with the RSA public key", header: jwtHeader{Alg: "HS256", Kid: "rs"}, claims: claims(nil), key: rsaDER, err: ErrUnsupportedAlg},
+		{name: "RS256 for an Ed25519 key", header: jwtHeader{Alg: "RS256", Kid: "ed"}, claims: claims(nil), key: rsaKey, err: ErrUnsupportedAlg},
+		{name: "alg none", header: jwtHeader{Alg: "none", Kid: "hs"}, claims: claims(nil), err: ErrUnsupportedAlg},
+		{name: "unknown kid", header: jwtHeader{Alg: "HS256", Kid: "other"}, claims: claims(nil), key: secret, err: ErrUnknownKey},
+		{name: "no kid among several keys", header: jwtHeader{Alg: "HS256"}, claims: claims(nil), key: secret, err: ErrUnknownKey},
+		{name: "expired", header: jwtHeader{Alg: "HS256", Kid: "hs"}, claims: claims(map[string]any{"exp": now.Add(-2 * time.Minute).Unix()}), key: secret, err: ErrTokenExpired},
+		{name: "expired within leeway", header: jwtHeader{Alg: "HS256", Kid: "hs"}, claims: claims(map[string]any{"exp": now.Add(-30 * time.Second).Unix()}), key: secret},
+		{name: "missing exp", header: jwtHeader{Alg: "HS256", Kid: "hs"}, claims: claims(map[string]any{"exp": nil}), key: secret, err: ErrInvalidClaims},
+		{name: "not valid yet", header: jwtHeader{Alg: "HS256", Kid: "hs"}, claims: claims(map[string]any{"nbf": now.Add(2 * time.Minute).Unix()}), key: secret, err: ErrInvalidClaims},
+		{name: "wrong issuer", header: jwtHeader{Alg: "HS256", Kid: "hs"}, claims: claims(map[string]any{"iss": "https://other"}), key: secret, err: ErrInvalidClaims},
+		{name: "wrong audience", header: jwtHeader{Alg: "HS256", Kid: "hs"}, claims: claims(map[string]any{"aud": "other"}), key: secret, err: ErrInvalidClaims},
+		{name: "missing sub", header: jwtHeader{Alg: "HS256", Kid: "hs"}, claims: claims(map[string]any{"sub": nil}), key: secret, err: ErrInvalidClaims},
+		{name: "other project", header: jwtHeader{Alg: "HS256", Kid: "hs"}, claims: claims(map[string]any{"projects": []string{"payroll"}}), key: secret, err: ErrProjectDenied},
+		{name: "no projects", header: jwtHeader{Alg: "HS256", Kid: "hs"}, claims: claims(map[string]any{"projects": nil}), key: secret, err: ErrProjectDenied},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token := signJWT(t, tt.header, tt.claims, tt.key)
+
+			principal, err := v.Verify(context.Background(), token, "billing")
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("error = %v, want %v", err, tt.err)
+			}
+			if tt.err != nil {
+				return
+			}
+
+			if principal.Subject != "svc" || principal.KeyId != tt.header.Kid {
+				t.Errorf("principal = %+v, want subject svc signed by %q", principal, tt.header.Kid)
+			}
+		})
+	}
+
+	if _, err := v.Verify(context.Background(), "not.a-token", "billing"); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("malformed token: error = %v, want %v", err, ErrInvalidToken)
+	}
+}
+
+func TestJWTVerifierSingleKeyWithoutKid(t *testing.T) {
+	secret := []byte("shared-secret")
+	v := NewJWTVerifier(WithJWTHMACKey("hs", secret))
+
+	token := signJWT(t, jwtHeader{Alg: "HS256"}, map[string]any{
+		"sub":      "svc",
+		"exp":      time.Now().Add(time.Hour).Unix(),
+		"projects": []string{"*"},
+	}, secret)
+
+	if _, err := v.Verify(context.Background(), token, "billing"); err != nil {
+		t.Fatal(err)
+	}
+}
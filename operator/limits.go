@@ -0,0 +1,254 @@
+package operator
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var ErrRateLimited = errors.New("rate limited")
+
+// connectionRetryAfter is suggested to clients rejected by a connection cap,
+// which frees up only when other connections close
+const connectionRetryAfter = 5 * time.Second
+
+// LimitReason names the limit a request was rejected by
+type LimitReason string
+
+const (
+	LimitProjectConnections   LimitReason = "project_connections"
+	LimitPrincipalConnections LimitReason = "principal_connections"
+	LimitConnectRate          LimitReason = "connect_rate"
+	LimitPublishRate          LimitReason = "publish_rate"
+)
+
+// Limits bounds what a single tenant can use. Zero values disable a limit.
+type Limits struct {
+	// MaxConnectionsPerProject caps concurrent connections to a project
+	MaxConnectionsPerProject int
+
+	// MaxConnectionsPerPrincipal caps concurrent connections of a principal,
+	// by subject; principals without a subject are not counted
+	MaxConnectionsPerPrincipal int
+
+	// ConnectRate is how many connection attempts per second an IP may make,
+	// with bursts up to ConnectBurst. The IP is taken from the request's
+	// remote address, so behind a proxy it is the proxy's.
+	ConnectRate  float64
+	ConnectBurst int
+
+	// PublishRate is how many events per second may be broadcast to a
+	// project, with bursts up to PublishBurst
+	PublishRate  float64
+	PublishBurst int
+}
+
+// RateLimitError is returned when a limit rejects an operation. It matches
+// ErrRateLimited with errors.Is.
+type RateLimitError struct {
+	Reason     LimitReason
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("rate limited (%s), retry after %s", e.Reason, e.RetryAfter)
+}
+
+func (e *RateLimitError) Is(target error) bool {
+	return target == ErrRateLimited
+}
+
+// LimitRecorder receives every rejection made by a limit, e.g. to export it
+// as a metric
+type LimitRecorder interface {
+	Rejected(reason LimitReason, project string)
+}
+
+var _ LimitRecorder = &LimitCounters{}
+
+// LimitCounters is the default LimitRecorder, counting rejections per reason
+type LimitCounters struct {
+	mu     sync.Mutex
+	counts map[LimitReason]uint64
+}
+
+// Rejected implements LimitRecorder.
+func (c *LimitCounters) Rejected(reason LimitReason, project string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.counts == nil {
+		c.counts = make(map[LimitReason]uint64)
+	}
+	c.counts[reason]++
+}
+
+// Snapshot returns the rejection counts so far
+func (c *LimitCounters) Snapshot() map[LimitReason]uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	out := make(map[LimitReason]uint64, len(c.counts))
+	for reason, count := range c.counts {
+		out[reason] = count
+	}
+
+	return out
+}
+
+// WithSSELimits enforces connection and publish limits
+func WithSSELimits(limits Limits) WithSSE {
+	return func(s *SSE) {
+		s.limits = newLimiter(limits)
+	}
+}
+
+// WithSSELimitRecorder replaces the default LimitCounters
+func WithSSELimitRecorder(recorder LimitRecorder) WithSSE {
+	return func(s *SSE) {
+		s.limitRecorder = recorder
+	}
+}
+
+// LimitCounters returns the default recorder, or nil when replaced through
+// WithSSELimitRecorder
+func (s *SSE) LimitCounters() *LimitCounters {
+	counters, _ := s.limitRecorder.(*LimitCounters)
+	return counters
+}
+
+type limiter struct {
+	projectConnections   *connectionCounter
+	principalConnections *connectionCounter
+	connect              *rateLimiter
+	publish              *rateLimiter
+}
+
+func newLimiter(limits Limits) *limiter {
+	l := &limiter{}
+	if limits.MaxConnectionsPerProject > 0 {
+		l.projectConnections = newConnectionCounter(limits.MaxConnectionsPerProject)
+	}
+	if limits.MaxConnectionsPerPrincipal > 0 {
+		l.principalConnections = newConnectionCounter(limits.MaxConnectionsPerPrincipal)
+	}
+	if limits.ConnectRate > 0 {
+		l.connect = newRateLimiter(limits.ConnectRate, limits.ConnectBurst)
+	}
+	if limits.PublishRate > 0 {
+		l.publish = newRateLimiter(limits.PublishRate, limits.PublishBurst)
+	}
+
+	return l
+}
+
+// allowConnect applies the connection attempt rate of the request's IP
+func (s *SSE) allowConnect(r *http.Request) error {
+	if s.limits == nil || s.limits.connect == nil {
+		return nil
+	}
+
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+
+	if ok, wait := s.limits.connect.allow(ip); !ok {
+		return s.rejected(LimitConnectRate, "", wait)
+	}
+
+	return nil
+}
+
+// acquireConnection takes a connection slot in each project and for the
+// principal. On success the returned release must be called once the client
+// is gone.
+func (s *SSE) acquireConnection(projects []string, principal *Principal) (func(), error) {
+	if s.limits == nil {
+		return func() {}, nil
+	}
+
+	counter := s.limits.projectConnections
+	var acquired []string
+	release := func() {
+		for _, project := range acquired {
+			counter.release(project)
+		}
+	}
+
+	if counter != nil {
+		for _, project := range projects {
+			if !counter.acquire(project) {
+				release()
+				return nil, s.rejected(LimitProjectConnections, project, connectionRetryAfter)
+			}
+			acquired = append(acquired, project)
+		}
+	}
+
+	principals := s.limits.principalConnections
+	if principal.Subject == "" {
+		principals = nil
+	}
+	if principals != nil && !principals.acquire(principal.Subject) {
+		release()
+		return nil, s.rejected(LimitPrincipalConnections, projects[0], connectionRetryAfter)
+	}
+
+	return func() {
+		release()
+		if principals != nil {
+			principals.release(principal.Subject)
+		}
+	}, nil
+}
+
+// allowPublish applies the publish rate of the project
+func (s *SSE) allowPublish(project string) error {
+	if s.limits == nil || s.limits.publish == nil {
+		return nil
+	}
+
+	if ok, wait := s.limits.publish.allow(project); !ok {
+		return s.rejected(LimitPublishRate, project, wait)
+	}
+
+	return nil
+}
+
+func (s *SSE) rejected(reason LimitReason, project string, retryAfter time.Duration) error {
+	slog.Warn("Rejected by limit", "reason", reason, "projectId", project)
+	s.limitRecorder.Rejected(reason, project)
+
+	return &RateLimitError{Reason: reason, RetryAfter: retryAfter}
+}
+
+func (s *SSE) cleanupLimits() {
+	if s.limits == nil {
+		return
+	}
+
+	if s.limits.connect != nil {
+		s.limits.connect.cleanup()
+	}
+	if s.limits.publish != nil {
+		s.limits.publish.cleanup()
+	}
+}
+
+// writeRateLimited answers 429 with a Retry-After in whole seconds
+func writeRateLimited(w http.ResponseWriter, err error) {
+	var limited *RateLimitError
+	if errors.As(err, &limited) {
+		seconds := int(math.Ceil(limited.RetryAfter.Seconds()))
+		w.Header().Set("Retry-After", strconv.Itoa(max(seconds, 1)))
+	}
+
+	http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+}
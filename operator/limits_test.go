@@ -0,0 +1,246 @@
+package operator
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/goccy/go-json"
+	"github.com/lamlv2305/sentinel/types"
+)
+
+// limitLog records rejections as project/reason
+type limitLog []string
+
+func (l *limitLog) Rejected(reason LimitReason, project string) {
+	*l = append(*l, project+"/"+string(reason))
+}
+
+func TestRateLimiter(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	l := newRateLimiter(2, 2)
+	l.now = func() time.Time { return now }
+
+	steps := []struct {
+		advance time.Duration
+		key     string
+		allowed bool
+		wait    time.Duration
+	}{
+		{key: "a", allowed: true},
+		{key: "a", allowed: true},
+		{key: "a", wait: 500 * time.Millisecond},
+		{key: "b", allowed: true},
+		{advance: 250 * time.Millisecond, key: "a", wait: 250 * time.Millisecond},
+		{advance: 250 * time.Millisecond, key: "a", allowed: true},
+		{key: "a", wait: 500 * time.Millisecond},
+		{advance: time.Hour, key: "a", allowed: true},
+		{key: "a", allowed: true},
+		{key: "a", wait: 500 * time.Millisecond},
+	}
+
+	for i, step := range steps {
+		now = now.Add(step.advance)
+		allowed, wait := l.allow(step.key)
+		if allowed != step.allowed || wait != step.wait {
+			t.Errorf("step %d: allow(%s) = %v, %s, want %v, %s", i, step.key, allowed, wait, step.allowed, step.wait)
+		}
+	}
+
+	// b is idle long enough to be full again, a was just used
+	now = now.Add(500 * time.Millisecond)
+	l.cleanup()
+	if _, ok := l.buckets["b"]; ok {
+		t.Error("idle bucket was kept")
+	}
+	if _, ok := l.buckets["a"]; !ok {
+		t.Error("active bucket was dropped")
+	}
+}
+
+func TestConnectionCounter(t *testing.T) {
+	c := newConnectionCounter(2)
+
+	if !c.acquire("a") || !c.acquire("a") {
+		t.Fatal("rejected a connection under the maximum")
+	}
+	if c.acquire("a") {
+		t.Fatal("accepted a connection over the maximum")
+	}
+	if !c.acquire("b") {
+		t.Fatal("keys share their count")
+	}
+
+	c.release("a")
+	if !c.acquire("a") {
+		t.Fatal("released slot was not reused")
+	}
+
+	c.release("b")
+	if _, ok := c.count["b"]; ok {
+		t.Error("key without connections was kept")
+	}
+}
+
+func TestAcquireConnection(t *testing.T) {
+	s := NewSSE(http.NewServeMux(), "/sse", WithSSELimits(Limits{MaxConnectionsPerProject: 2, MaxConnectionsPerPrincipal: 1}))
+	alice, bob, anonymous := &Principal{Subject: "alice"}, &Principal{Subject: "bob"}, &Principal{}
+
+	releaseAlice, err := s.acquireConnection([]string{"billing"}, alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = s.acquireConnection([]string{"payroll"}, alice)
+	var limited *RateLimitError
+	if !errors.As(err, &limited) || limited.Reason != LimitPrincipalConnections || limited.RetryAfter != connectionRetryAfter {
+		t.Fatalf("second connection of a principal: error = %v, want %s", err, LimitPrincipalConnections)
+	}
+	if s.limits.projectConnections.count["payroll"] != 0 {
+		t.Error("project slot kept after the principal was rejected")
+	}
+
+	// Principals without a subject are not counted per principal
+	if _, err := s.acquireConnection([]string{"billing"}, anonymous); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = s.acquireConnection([]string{"payroll", "billing"}, bob)
+	if !errors.As(err, &limited) || limited.Reason != LimitProjectConnections {
+		t.Fatalf("connection over the project cap: error = %v, want %s", err, LimitProjectConnections)
+	}
+	if s.limits.projectConnections.count["payroll"] != 0 {
+		t.Error("slot of an earlier project kept after a later one was rejected")
+	}
+
+	releaseAlice()
+	if _, err := s.acquireConnection([]string{"billing"}, bob); err != nil {
+		t.Errorf("connection after a release: %v", err)
+	}
+	if _, err := s.acquireConnection([]string{"payroll"}, alice); err != nil {
+		t.Errorf("principal connection after a release: %v", err)
+	}
+
+	counts := s.LimitCounters().Snapshot()
+	if counts[LimitPrincipalConnections] != 1 || counts[LimitProjectConnections] != 1 {
+		t.Errorf("counters = %v, want one rejection per cap", counts)
+	}
+}
+
+func TestLimitsHTTP(t *testing.T) {
+	verifier := WithSSECredentialVerifier(func(ctx context.Context, apikey, project string) (*Principal, error) {
+		return &Principal{Subject: apikey, Projects: []string{"billing"}, Permissions: []Permission{PermissionPublish}}, nil
+	})
+
+	request := func(method, path string, body []byte, remoteAddr string) *http.Request {
+		r := httptest.NewRequest(method, path, bytes.NewReader(body))
+		r.RemoteAddr = remoteAddr
+		r.Header.Set(types.HeaderAPIKey, "svc")
+		r.Header.Set(types.HeaderProject, "billing")
+		return r
+	}
+
+	t.Run("connect rate", func(t *testing.T) {
+		s := NewSSE(http.NewServeMux(), "/sse", verifier, WithSSELimits(Limits{ConnectRate: 0.5, ConnectBurst: 1, MaxConnectionsPerProject: 1}))
+
+		// Hold the only slot of the project so accepted attempts return at once
+		release, err := s.acquireConnection([]string{"billing"}, &Principal{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer release()
+
+		tests := []struct {
+			remoteAddr string
+			status     int
+			retryAfter string
+		}{
+			{remoteAddr: "10.0.0.1:1000", status: http.StatusTooManyRequests, retryAfter: "5"},
+			{remoteAddr: "10.0.0.1:1001", status: http.StatusTooManyRequests, retryAfter: "2"},
+			{remoteAddr: "10.0.0.2:1000", status: http.StatusTooManyRequests, retryAfter: "5"},
+		}
+
+		for _, tt := range tests {
+			w := httptest.NewRecorder()
+			s.OnConnected(w, request(http.MethodGet, "/sse", nil, tt.remoteAddr))
+			if w.Code != tt.status || w.Header().Get("Retry-After") != tt.retryAfter {
+				t.Errorf("%s: status = %d, Retry-After = %q, want %d, %q", tt.remoteAddr, w.Code, w.Header().Get("Retry-After"), tt.status, tt.retryAfter)
+			}
+		}
+
+		counts := s.LimitCounters().Snapshot()
+		if counts[LimitConnectRate] != 1 || counts[LimitProjectConnections] != 2 {
+			t.Errorf("counters = %v, want 1 connect rate and 2 project connection rejections", counts)
+		}
+	})
+
+	t.Run("publish rate", func(t *testing.T) {
+		recorder := &limitLog{}
+		s := NewSSE(http.NewServeMux(), "/sse", verifier, WithSSELimits(Limits{PublishRate: 1, PublishBurst: 1}), WithSSELimitRecorder(recorder))
+
+		event := types.ChangedEvent{Resource: types.Resource{ProjectId: "billing", ResourceId: "config"}}
+		if _, err := s.Broadcast(context.Background(), event); err != nil {
+			t.Fatal(err)
+		}
+
+		_, err := s.Broadcast(context.Background(), event)
+		var limited *RateLimitError
+		if !errors.Is(err, ErrRateLimited) || !errors.As(err, &limited) || limited.Reason != LimitPublishRate {
+			t.Fatalf("second broadcast: error = %v, want %s", err, LimitPublishRate)
+		}
+
+		// Other projects have their own budget
+		other := types.ChangedEvent{Resource: types.Resource{ProjectId: "payroll", ResourceId: "config"}}
+		if _, err := s.Broadcast(context.Background(), other); err != nil {
+			t.Errorf("broadcast to another project: %v", err)
+		}
+
+		body, err := json.Marshal(event)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := httptest.NewRecorder()
+		s.Publish(w, request(http.MethodPost, "/publish", body, "10.0.0.1:1000"))
+		if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "1" {
+			t.Errorf("publish: status = %d, Retry-After = %q, want 429, \"1\"", w.Code, w.Header().Get("Retry-After"))
+		}
+
+		if s.LimitCounters() != nil {
+			t.Error("LimitCounters returned the default counters after WithSSELimitRecorder")
+		}
+		if want := (limitLog{"billing/publish_rate", "billing/publish_rate"}); !slices.Equal(*recorder, want) {
+			t.Errorf("recorded %v, want %v", *recorder, want)
+		}
+	})
+}
+
+func TestWriteRateLimited(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		retryAfter string
+	}{
+		{name: "rounded up", err: &RateLimitError{Reason: LimitPublishRate, RetryAfter: 1500 * time.Millisecond}, retryAfter: "2"},
+		{name: "at least a second", err: &RateLimitError{Reason: LimitPublishRate, RetryAfter: 10 * time.Millisecond}, retryAfter: "1"},
+		{name: "wrapped", err: errors.Join(errors.New("publish"), &RateLimitError{RetryAfter: 3 * time.Second}), retryAfter: "3"},
+		{name: "without a delay", err: ErrRateLimited},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			writeRateLimited(w, tt.err)
+			if w.Code != http.StatusTooManyRequests {
+				t.Errorf("status = %d, want %d", w.Code, http.StatusTooManyRequests)
+			}
+			if got := w.Header().Get("Retry-After"); got != tt.retryAfter {
+				t.Errorf("Retry-After = %q, want %q", got, tt.retryAfter)
+			}
+		})
+	}
+}
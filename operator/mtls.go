@@ -0,0 +1,126 @@
+package operator
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+)
+
+var (
+	ErrNoCertMapping  = errors.New("mtls: no mapping rule matches the client certificate")
+	ErrInvalidSANRule = errors.New("mtls: invalid SAN rule")
+)
+
+// CertMapper derives the principal of a client from its verified certificate
+type CertMapper func(cert *x509.Certificate) (*Principal, error)
+
+// SANRule maps client certificates whose URI or DNS SAN matches Pattern.
+//
+// Pattern is compared segment by segment, split on "/", using path.Match
+// syntax within a segment. A "{project}" segment matches any value and adds
+// it to the allowed projects, e.g. "spiffe://corp/projects/{project}/agents/*".
+// A rule must grant at least one project, through Projects or a "{project}"
+// segment; "*" in Projects grants every project.
+type SANRule struct {
+	Pattern     string
+	Projects    []string
+	Groups      []string
+	Permissions []Permission
+}
+
+// NewSANMapper returns a CertMapper applying the first matching rule. URI
+// SANs are tried before DNS SANs. The matched SAN becomes the subject and the
+// certificate fingerprint the key id. Rules granting no project are rejected,
+// as a principal without projects is allowed on all of them.
+//
+// Certificates are only checked for expiry after the handshake; revocation
+// through CRLs or OCSP is out of scope and left to the TLS configuration, e.g.
+// tls.Config.VerifyPeerCertificate.
+func NewSANMapper(rules ...SANRule) (CertMapper, error) {
+	for _, rule := range rules {
+		if err := rule.validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	return func(cert *x509.Certificate) (*Principal, error) {
+		var sans []string
+		for _, uri := range cert.URIs {
+			sans = append(sans, uri.String())
+		}
+		sans = append(sans, cert.DNSNames...)
+
+		for _, rule := range rules {
+			for _, san := range sans {
+				project, ok := rule.match(san)
+				if !ok {
+					continue
+				}
+
+				principal := &Principal{
+					Subject:     san,
+					KeyId:       Fingerprint(cert),
+					Projects:    append([]string(nil), rule.Projects...),
+					Groups:      rule.Groups,
+					Permissions: rule.Permissions,
+				}
+				if project != "" {
+					principal.Projects = append(principal.Projects, project)
+				}
+
+				return principal, nil
+			}
+		}
+
+		return nil, ErrNoCertMapping
+	}, nil
+}
+
+func (rule SANRule) validate() error {
+	if rule.Pattern == "" {
+		return fmt.Errorf("%w: empty pattern", ErrInvalidSANRule)
+	}
+
+	if !slices.Contains(strings.Split(rule.Pattern, "/"), "{project}") && !slices.ContainsFunc(rule.Projects, func(project string) bool { return project != "" }) {
+		return fmt.Errorf("%w: %q grants no project", ErrInvalidSANRule, rule.Pattern)
+	}
+
+	return nil
+}
+
+// match reports whether the SAN matches the rule and returns the captured project
+func (rule SANRule) match(san string) (project string, ok bool) {
+	patterns := strings.Split(rule.Pattern, "/")
+	segments := strings.Split(san, "/")
+	if len(patterns) != len(segments) {
+		return "", false
+	}
+
+	for i, pattern := range patterns {
+		if pattern == "{project}" {
+			if segments[i] == "" {
+				return "", false
+			}
+			project = segments[i]
+			continue
+		}
+
+		if matched, err := path.Match(pattern, segments[i]); err != nil || !matched {
+			return "", false
+		}
+	}
+
+	return project, true
+}
+
+// Fingerprint returns the hex SHA-256 of the certificate, used as key id of
+// certificate principals so they can be revoked individually
+func Fingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
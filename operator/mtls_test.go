@@ -0,0 +1,284 @@
+package operator
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/goccy/go-json"
+	"github.com/lamlv2305/sentinel/types"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pool *x509.CertPool
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "sentinel test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+
+	return &testCA{cert: cert, key: key, pool: pool}
+}
+
+// issue returns a client certificate with the given URI and DNS SANs
+func (ca *testCA) issue(t *testing.T, uris []string, dnsNames ...string) tls.Certificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: "agent"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		DNSNames:     dnsNames,
+	}
+	for _, raw := range uris {
+		uri, err := url.Parse(raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		template.URIs = append(template.URIs, uri)
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+func TestSANMapper(t *testing.T) {
+	ca := newTestCA(t)
+	mapper, err := NewSANMapper(
+		SANRule{
+			Pattern:     "spiffe://corp/projects/{project}/agents/*",
+			Permissions: []Permission{PermissionSubscribe},
+		},
+		SANRule{
+			Pattern:     "*.agents.corp",
+			Projects:    []string{"shared"},
+			Permissions: []Permission{PermissionSubscribe},
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		uris     []string
+		dnsNames []string
+		subject  string
+		projects []string
+		err      error
+	}{
+		{
+			name:     "uri with project",
+			uris:     []string{"spiffe://corp/projects/billing/agents/a1"},
+			subject:  "spiffe://corp/projects/billing/agents/a1",
+			projects: []string{"billing"},
+		},
+		{
+			name:     "dns name",
+			dnsNames: []string{"a1.agents.corp"},
+			subject:  "a1.agents.corp",
+			projects: []string{"shared"},
+		},
+		{
+			name:     "uri before dns name",
+			uris:     []string{"spiffe://corp/projects/billing/agents/a1"},
+			dnsNames: []string{"a1.agents.corp"},
+			subject:  "spiffe://corp/projects/billing/agents/a1",
+			projects: []string{"billing"},
+		},
+		{
+			name: "extra segment",
+			uris: []string{"spiffe://corp/projects/billing/agents/a1/x"},
+			err:  ErrNoCertMapping,
+		},
+		{
+			name: "empty project",
+			uris: []string{"spiffe://corp/projects//agents/a1"},
+			err:  ErrNoCertMapping,
+		},
+		{
+			name:     "unknown domain",
+			dnsNames: []string{"a1.agents.other"},
+			err:      ErrNoCertMapping,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cert := ca.issue(t, tt.uris, tt.dnsNames...)
+
+			principal, err := mapper(cert.Leaf)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("error = %v, want %v", err, tt.err)
+			}
+			if tt.err != nil {
+				return
+			}
+
+			if principal.Subject != tt.subject {
+				t.Errorf("subject = %q, want %q", principal.Subject, tt.subject)
+			}
+			if !slices.Equal(principal.Projects, tt.projects) {
+				t.Errorf("projects = %v, want %v", principal.Projects, tt.projects)
+			}
+			if principal.KeyId != Fingerprint(cert.Leaf) {
+				t.Errorf("key id = %q, want the certificate fingerprint", principal.KeyId)
+			}
+		})
+	}
+}
+
+func TestSANMapperRejectsRulesWithoutProject(t *testing.T) {
+	tests := []struct {
+		name string
+		rule SANRule
+		err  error
+	}{
+		{name: "project segment", rule: SANRule{Pattern: "spiffe://corp/projects/{project}/agents/*"}},
+		{name: "explicit projects", rule: SANRule{Pattern: "*.agents.corp", Projects: []string{"shared"}}},
+		{name: "all projects", rule: SANRule{Pattern: "*.agents.corp", Projects: []string{"*"}}},
+		{name: "no project", rule: SANRule{Pattern: "*.agents.corp"}, err: ErrInvalidSANRule},
+		{name: "empty project", rule: SANRule{Pattern: "*.agents.corp", Projects: []string{""}}, err: ErrInvalidSANRule},
+		{name: "project placeholder inside a segment", rule: SANRule{Pattern: "spiffe://corp/x{project}/agents/*"}, err: ErrInvalidSANRule},
+		{name: "empty pattern", rule: SANRule{Projects: []string{"shared"}}, err: ErrInvalidSANRule},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			valid := SANRule{Pattern: "spiffe://corp/projects/{project}/agents/*"}
+			if _, err := NewSANMapper(valid, tt.rule); !errors.Is(err, tt.err) {
+				t.Errorf("error = %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestClientCertAuth(t *testing.T) {
+	ca := newTestCA(t)
+
+	mapper, err := NewSANMapper(SANRule{
+		Pattern:     "spiffe://corp/projects/{project}/agents/*",
+		Permissions: []Permission{PermissionSubscribe},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &SSE{credentials: defaultCredentialSource(), certMapper: mapper}
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth, status := s.authenticate(r)
+		if status != http.StatusOK {
+			http.Error(w, http.StatusText(status), status)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(auth.principal)
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.VerifyClientCertIfGiven, ClientCAs: ca.pool}
+	server.StartTLS()
+	defer server.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(server.Certificate())
+
+	tests := []struct {
+		name    string
+		uri     string
+		project string
+		status  int
+	}{
+		{name: "mapped", uri: "spiffe://corp/projects/billing/agents/a1", project: "billing", status: http.StatusOK},
+		{name: "other project", uri: "spiffe://corp/projects/billing/agents/a1", project: "payroll", status: http.StatusForbidden},
+		{name: "unmapped", uri: "spiffe://other/agents/a1", project: "billing", status: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+				RootCAs:      roots,
+				Certificates: []tls.Certificate{ca.issue(t, []string{tt.uri})},
+			}}}
+
+			req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+			req.Header.Set(types.HeaderProject, tt.project)
+
+			resp, err := client.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != tt.status {
+				t.Fatalf("status = %d, want %d", resp.StatusCode, tt.status)
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+
+			var principal Principal
+			if err := json.NewDecoder(resp.Body).Decode(&principal); err != nil {
+				t.Fatal(err)
+			}
+			if principal.Subject != tt.uri || !slices.Equal(principal.Projects, []string{tt.project}) {
+				t.Errorf("principal = %+v, want subject %q and project %q", principal, tt.uri, tt.project)
+			}
+		})
+	}
+}
@@ -0,0 +1,248 @@
+package operator
+
+import (
+	"maps"
+	"sync"
+	"time"
+)
+
+// SlowConsumerPolicy decides what happens to a client whose outbox is full
+type SlowConsumerPolicy string
+
+const (
+	// SlowConsumerDisconnect disconnects clients whose outbox stays over
+	// capacity for longer than the send timeout
+	SlowConsumerDisconnect SlowConsumerPolicy = "disconnect"
+
+	// SlowConsumerDropOldest drops the oldest queued message to make room
+	SlowConsumerDropOldest SlowConsumerPolicy = "drop_oldest"
+
+	// SlowConsumerCoalesce makes every client coalesce, see coalesceUpdates;
+	// an outbox over capacity with distinct resources is handled like
+	// disconnect
+	SlowConsumerCoalesce SlowConsumerPolicy = "coalesce"
+
+	// SlowConsumerSpill stops queueing and, once the client has caught up,
+	// replays what it missed from the project journal
+	SlowConsumerSpill SlowConsumerPolicy = "spill"
+)
+
+// hardCapFactor bounds the queue of the disconnect and coalesce policies, as
+// a multiple of the capacity. Clients reaching it are dropped right away
+// instead of after the send timeout.
+const hardCapFactor = 2
+
+// message is a resource event on its way to a client
+type message struct {
+	project string
+	seq     uint64 // journal sequence, 0 for messages outside the journal
+	key     string // project and resource id, used to coalesce
+	data    string
+}
+
+// outbox queues the messages of a client until its stream writes them
+type outbox struct {
+	capacity int
+	policy   SlowConsumerPolicy
+
+	mu        sync.Mutex
+	queue     []message
+	spilled   bool      // messages from next on are to be replayed
+	next      cursor    // per project, the first message not yet taken
+	overSince time.Time // when the queue went over capacity, if it is
+
+	latest map[string]uint64 // seq of the latest queued message per resource, when coalescing
+	stale  int               // queued messages superseded by a later one
+
+	ready chan struct{} // signaled when there is something to write
+}
+
+func newOutbox(capacity int, policy SlowConsumerPolicy) *outbox {
+	if capacity < 1 {
+		capacity = 1
+	}
+
+	o := &outbox{
+		capacity: capacity,
+		policy:   policy,
+		next:     make(cursor),
+		ready:    make(chan struct{}, 1),
+	}
+
+	if policy == SlowConsumerCoalesce {
+		o.coalesceUpdates()
+	}
+
+	return o
+}
+
+// coalesceUpdates makes the outbox keep only the latest pending message per
+// resource. Superseded messages are skipped where they were queued, so the
+// messages written keep the order in which they were broadcast.
+func (o *outbox) coalesceUpdates() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.latest == nil {
+		o.latest = make(map[string]uint64)
+	}
+}
+
+// offer queues the message without blocking, applying the policy when the
+// outbox is full. It returns how long the queue has been over capacity,
+// whether an older message was dropped to make room, and whether the queue
+// reached its hard cap, in which case the message was not queued.
+func (o *outbox) offer(msg message) (over time.Duration, dropped, full bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if msg.seq != 0 && (o.spilled || msg.seq < o.next[msg.project]) {
+		// Replayed from the journal instead
+		return 0, false, false
+	}
+
+	if o.latest != nil && msg.key != "" && msg.seq != 0 {
+		if _, queued := o.latest[msg.key]; queued {
+			o.stale++
+		}
+		o.latest[msg.key] = msg.seq
+
+		if o.stale > o.capacity {
+			o.compact()
+		}
+	}
+
+	if len(o.queue)-o.stale >= o.capacity {
+		switch {
+		case o.policy == SlowConsumerDropOldest:
+			o.compact()
+			if o.latest != nil {
+				delete(o.latest, o.queue[0].key)
+			}
+			copy(o.queue, o.queue[1:])
+			o.queue = o.queue[:len(o.queue)-1]
+			dropped = true
+
+		case o.policy == SlowConsumerSpill && msg.seq != 0:
+			o.spilled = true
+			signal(o.ready)
+			return 0, false, false
+
+		case len(o.queue)-o.stale >= hardCapFactor*o.capacity:
+			return o.over(), false, true
+
+		case o.overSince.IsZero():
+			o.overSince = time.Now()
+		}
+	}
+
+	o.queue = append(o.queue, msg)
+	if msg.seq != 0 {
+		o.next[msg.project] = msg.seq + 1
+	}
+	signal(o.ready)
+
+	return o.over(), dropped, false
+}
+
+// overFor returns how long the queue has been over capacity
+func (o *outbox) overFor() time.Duration {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	return o.over()
+}
+
+func (o *outbox) over() time.Duration {
+	if o.overSince.IsZero() {
+		return 0
+	}
+
+	return time.Since(o.overSince)
+}
+
+// fill returns how many messages are queued, not counting superseded ones
+func (o *outbox) fill() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	return len(o.queue) - o.stale
+}
+
+// take returns every queued message
+func (o *outbox) take() []message {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.compact()
+	queue := o.queue
+	o.queue = nil
+	o.overSince = time.Time{}
+	clear(o.latest)
+
+	return queue
+}
+
+// start sets the first message expected from each project. With replay,
+// messages from there on are replayed from the journal first.
+func (o *outbox) start(next cursor, replay bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.next = next
+	if replay {
+		o.spilled = true
+		signal(o.ready)
+	}
+}
+
+// pending returns where replay has to start, if the outbox spilled
+func (o *outbox) pending() (cursor, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	return maps.Clone(o.next), o.spilled
+}
+
+// resume records that messages before next were replayed. Live delivery
+// resumes once nothing newer than last is left to replay in any project;
+// until then it reports false and the caller replays again.
+func (o *outbox) resume(next cursor, last func(project string) uint64) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.next = next
+	for project, seq := range next {
+		if last(project) >= seq {
+			return false
+		}
+	}
+
+	o.spilled = false
+	return true
+}
+
+// compact drops the queued messages superseded by a later one
+func (o *outbox) compact() {
+	if o.stale == 0 {
+		return
+	}
+
+	live := o.queue[:0]
+	for _, msg := range o.queue {
+		if msg.key == "" || msg.seq == 0 || o.latest[msg.key] == msg.seq {
+			live = append(live, msg)
+		}
+	}
+	clear(o.queue[len(live):])
+
+	o.queue = live
+	o.stale = 0
+}
+
+func signal(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
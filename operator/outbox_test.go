@@ -0,0 +1,296 @@
+package operator
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/goccy/go-json"
+	"github.com/lamlv2305/sentinel/types"
+)
+
+func TestOutboxHardCap(t *testing.T) {
+	for _, policy := range []SlowConsumerPolicy{SlowConsumerDisconnect, SlowConsumerCoalesce} {
+		t.Run(string(policy), func(t *testing.T) {
+			o := newOutbox(2, policy)
+
+			for i := range hardCapFactor * 2 {
+				seq := uint64(i + 1)
+				if _, _, full := o.offer(message{project: "p", seq: seq, key: "p/" + strconv.Itoa(i)}); full {
+					t.Fatalf("outbox full after %d messages", i)
+				}
+			}
+
+			if _, _, full := o.offer(message{project: "p", seq: 5, key: "p/4"}); !full {
+				t.Fatal("outbox grew past its hard cap")
+			}
+			if got := o.fill(); got != hardCapFactor*2 {
+				t.Fatalf("fill = %d, want %d", got, hardCapFactor*2)
+			}
+		})
+	}
+}
+
+// seqs returns the journal sequences of the messages
+func seqs(messages []message) []uint64 {
+	out := make([]uint64, len(messages))
+	for i, msg := range messages {
+		out[i] = msg.seq
+	}
+
+	return out
+}
+
+func TestOutboxCoalesce(t *testing.T) {
+	o := newOutbox(10, SlowConsumerDisconnect)
+	o.coalesceUpdates()
+
+	offers := []message{
+		{project: "p", seq: 1, key: "p/a"},
+		{project: "p", seq: 2, key: "p/b"},
+		{project: "p", seq: 3, key: "p/a"},
+		{project: "p", seq: 0, data: "control"},
+		{project: "p", seq: 4, key: "p/c"},
+		{project: "p", seq: 5, key: "p/b"},
+	}
+	for _, msg := range offers {
+		o.offer(msg)
+	}
+
+	if got := o.fill(); got != 4 {
+		t.Errorf("fill = %d, want 4 without superseded messages", got)
+	}
+
+	// Latest per resource, in the order they were broadcast
+	if got, want := seqs(o.take()), []uint64{3, 0, 4, 5}; !slices.Equal(got, want) {
+		t.Errorf("take = %v, want %v", got, want)
+	}
+
+	// Coalescing starts over once the queue was taken
+	o.offer(message{project: "p", seq: 6, key: "p/a"})
+	if got, want := seqs(o.take()), []uint64{6}; !slices.Equal(got, want) {
+		t.Errorf("take after take = %v, want %v", got, want)
+	}
+
+	// Messages already queued or taken are not queued again
+	o.offer(message{project: "p", seq: 6, key: "p/a"})
+	if got := o.take(); len(got) != 0 {
+		t.Errorf("take = %v, want nothing for an old message", seqs(got))
+	}
+}
+
+func TestOutboxDropOldest(t *testing.T) {
+	tests := []struct {
+		name     string
+		coalesce bool
+		offers   []message
+		dropped  []bool
+		want     []uint64
+	}{
+		{
+			name:    "distinct resources",
+			offers:  []message{{seq: 1, key: "p/a"}, {seq: 2, key: "p/b"}, {seq: 3, key: "p/c"}, {seq: 4, key: "p/d"}},
+			dropped: []bool{false, false, true, true},
+			want:    []uint64{3, 4},
+		},
+		{
+			name:     "superseded messages do not count",
+			coalesce: true,
+			offers:   []message{{seq: 1, key: "p/a"}, {seq: 2, key: "p/b"}, {seq: 3, key: "p/a"}, {seq: 4, key: "p/c"}},
+			dropped:  []bool{false, false, false, true},
+			want:     []uint64{3, 4},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := newOutbox(2, SlowConsumerDropOldest)
+			if tt.coalesce {
+				o.coalesceUpdates()
+			}
+
+			for i, msg := range tt.offers {
+				msg.project = "p"
+				over, dropped, full := o.offer(msg)
+				if dropped != tt.dropped[i] || full || over != 0 {
+					t.Errorf("offer %d: over = %s, dropped = %v, full = %v, want dropped = %v", msg.seq, over, dropped, full, tt.dropped[i])
+				}
+			}
+
+			if got := seqs(o.take()); !slices.Equal(got, tt.want) {
+				t.Errorf("take = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOutboxSpill(t *testing.T) {
+	o := newOutbox(2, SlowConsumerSpill)
+	o.start(cursor{"p": 1}, false)
+
+	for seq := range uint64(4) {
+		o.offer(message{project: "p", seq: seq + 1})
+	}
+
+	// Messages outside the journal are still queued
+	o.offer(message{project: "p", data: "control"})
+
+	if got, want := seqs(o.take()), []uint64{1, 2, 0}; !slices.Equal(got, want) {
+		t.Errorf("take = %v, want %v", got, want)
+	}
+
+	next, spilled := o.pending()
+	if !spilled || next["p"] != 3 {
+		t.Fatalf("pending = %v, %v, want replay from 3", next, spilled)
+	}
+
+	// Live delivery resumes only once nothing is left to replay
+	if o.resume(cursor{"p": 4}, func(string) uint64 { return 4 }) {
+		t.Error("resumed with message 4 left to replay")
+	}
+	if !o.resume(cursor{"p": 5}, func(string) uint64 { return 4 }) {
+		t.Error("did not resume after replaying message 4")
+	}
+
+	o.offer(message{project: "p", seq: 4})
+	o.offer(message{project: "p", seq: 5})
+	if got, want := seqs(o.take()), []uint64{5}; !slices.Equal(got, want) {
+		t.Errorf("take after resume = %v, want %v", got, want)
+	}
+}
+
+// streamEvent is an event written to an SSE stream
+type streamEvent struct {
+	id      string
+	project string
+	version string
+}
+
+// readStream parses the events written to the recorder since the last call
+func readStream(t *testing.T, w *httptest.ResponseRecorder) []streamEvent {
+	t.Helper()
+
+	var events []streamEvent
+	for _, block := range strings.Split(w.Body.String(), "\n\n") {
+		var event streamEvent
+		for _, line := range strings.Split(block, "\n") {
+			if id, ok := strings.CutPrefix(line, "id: "); ok {
+				event.id = id
+			}
+			if data, ok := strings.CutPrefix(line, "data: "); ok {
+				decoded, err := base64.StdEncoding.DecodeString(data)
+				if err != nil {
+					continue // control message
+				}
+
+				var changed types.ChangedEvent
+				if err := json.Unmarshal(decoded, &changed); err != nil {
+					t.Fatal(err)
+				}
+				event.project, event.version = changed.Resource.ProjectId, changed.Resource.Version
+			}
+		}
+		if event.version != "" {
+			events = append(events, event)
+		}
+	}
+	w.Body.Reset()
+
+	return events
+}
+
+func versions(events []streamEvent) []string {
+	out := make([]string, len(events))
+	for i, event := range events {
+		out[i] = event.project + "/" + event.version
+	}
+
+	return out
+}
+
+func TestSpillReplay(t *testing.T) {
+	s := NewSSE(http.NewServeMux(), "/sse", WithSSEJournal(100), WithSSEBufferSize(2), WithSSESlowConsumerPolicy(SlowConsumerSpill))
+
+	connect := func(id, lastEventId string) *Client {
+		r := httptest.NewRequest(http.MethodGet, "/sse", nil)
+		if lastEventId != "" {
+			r.Header.Set("Last-Event-ID", lastEventId)
+		}
+
+		client := NewClient(id, "billing")
+		client.Projects = []string{"billing", "payroll"}
+		client.Principal = &Principal{Subject: id}
+		client.outbox = newOutbox(s.bufferSize, s.slowConsumer)
+		s.startDelivery(r, client)
+		s.hub.add(client)
+		return client
+	}
+
+	// write drains the client like its stream does
+	write := func(client *Client, w *httptest.ResponseRecorder) {
+		for _, msg := range client.outbox.take() {
+			if err := s.writeMessage(w, client, msg, w); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := s.replay(w, client, w); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	publish := func(project, version string, target Target) {
+		event := types.ChangedEvent{Resource: types.Resource{ProjectId: project, ResourceId: "config", Version: version}}
+		if _, err := s.BroadcastTo(context.Background(), event, target); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	client := connect("c1", "")
+	publish("billing", "b1", Target{})
+	publish("payroll", "p1", Target{})
+	publish("billing", "b2", Target{})
+	publish("payroll", "p2", Target{ConnectionIds: []string{"other"}})
+	publish("payroll", "p3", Target{})
+
+	if _, spilled := client.outbox.pending(); !spilled {
+		t.Fatal("outbox did not spill")
+	}
+
+	w := httptest.NewRecorder()
+	write(client, w)
+	events := readStream(t, w)
+
+	// Journal order within each project, targeted events skipped
+	want := []string{"billing/b1", "payroll/p1", "billing/b2", "payroll/p3"}
+	if got := versions(events); !slices.Equal(got, want) {
+		t.Fatalf("stream = %v, want %v", got, want)
+	}
+
+	// Live delivery resumed
+	publish("billing", "b3", Target{})
+	write(client, w)
+	if got := versions(readStream(t, w)); !slices.Equal(got, []string{"billing/b3"}) {
+		t.Fatalf("stream after replay = %v, want [billing/b3]", got)
+	}
+
+	// Reconnecting after the second event resumes both projects from there
+	s.hub.remove(client)
+	resumed := connect("c2", events[1].id)
+	write(resumed, w)
+	want = []string{"billing/b2", "billing/b3", "payroll/p3"}
+	if got := versions(readStream(t, w)); !slices.Equal(got, want) {
+		t.Errorf("resumed stream = %v, want %v", got, want)
+	}
+
+	// A Last-Event-ID of another journal starts at the head
+	fresh := connect("c3", "other."+strings.SplitN(events[1].id, ".", 2)[1])
+	write(fresh, w)
+	if got := readStream(t, w); len(got) != 0 {
+		t.Errorf("stream of a foreign id = %v, want nothing", versions(got))
+	}
+}
@@ -0,0 +1,109 @@
+package operator
+
+import (
+	"log/slog"
+	"path"
+	"slices"
+	"strings"
+)
+
+type Permission string
+
+const (
+	PermissionSubscribe Permission = "subscribe"
+	PermissionPublish   Permission = "publish"
+	PermissionAdmin     Permission = "admin"
+	PermissionSecrets   Permission = "secrets"
+)
+
+// Principal is the identity a credential resolves to, with the scope it grants
+type Principal struct {
+	Subject string `json:"subject"`
+
+	// KeyId identifies the credential used, e.g. an apikey id or a JWT kid,
+	// so several keys of the same subject can be told apart and revoked
+	KeyId string `json:"key_id,omitempty"`
+
+	// Labels are free-form attributes of the principal such as tenant or team
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// Projects the principal may access; empty means no restriction
+	Projects []string `json:"projects,omitempty"`
+
+	// Groups are patterns of resource groups the principal may receive; empty
+	// means every group
+	Groups []string `json:"groups,omitempty"`
+
+	Permissions []Permission `json:"permissions,omitempty"`
+}
+
+// LogValue implements slog.LogValuer.
+func (p *Principal) LogValue() slog.Value {
+	if p == nil {
+		return slog.StringValue("anonymous")
+	}
+
+	return slog.GroupValue(
+		slog.String("subject", p.Subject),
+		slog.String("key_id", p.KeyId),
+	)
+}
+
+// HasPermission reports whether the principal holds the permission. Admins
+// hold every permission but secrets, which must always be granted explicitly.
+func (p *Principal) HasPermission(perm Permission) bool {
+	if p == nil {
+		return false
+	}
+
+	if slices.Contains(p.Permissions, perm) {
+		return true
+	}
+
+	return perm != PermissionSecrets && slices.Contains(p.Permissions, PermissionAdmin)
+}
+
+// AllowsProject reports whether the principal may access the project
+func (p *Principal) AllowsProject(project string) bool {
+	if p == nil {
+		return false
+	}
+
+	return len(p.Projects) == 0 || slices.Contains(p.Projects, "*") || slices.Contains(p.Projects, project)
+}
+
+// AllowsGroup reports whether the principal may receive resources of the group
+func (p *Principal) AllowsGroup(group string) bool {
+	if p == nil {
+		return false
+	}
+
+	if len(p.Groups) == 0 {
+		return true
+	}
+
+	for _, pattern := range p.Groups {
+		if matchGroup(pattern, group) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matchGroup matches a group against an exact name, a glob such as
+// "payments/*", or a prefix such as "payments/**" which matches "payments"
+// and the groups nested in it, but not "paymentsXYZ"
+func matchGroup(pattern, group string) bool {
+	if pattern == "*" || pattern == "**" || pattern == group {
+		return true
+	}
+
+	if prefix, ok := strings.CutSuffix(pattern, "**"); ok {
+		prefix = strings.TrimSuffix(prefix, "/")
+		return group == prefix || strings.HasPrefix(group, prefix+"/")
+	}
+
+	matched, err := path.Match(pattern, group)
+	return err == nil && matched
+}
@@ -0,0 +1,78 @@
+package operator
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/goccy/go-json"
+	"github.com/lamlv2305/sentinel/types"
+)
+
+// maxPublishBody bounds the size of a published event
+const maxPublishBody = 4 << 20
+
+// Publish accepts a types.ChangedEvent as JSON in a POST request and
+// broadcasts it. The caller authenticates like a subscriber and needs the
+// publish action on the event's project and group. The connection and
+// selector query parameters target the event, see Target.
+func (s *SSE) Publish(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	auth, status := s.authenticate(r)
+	if status != http.StatusOK {
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+
+	target, err := parseTarget(r)
+	if err != nil {
+		http.Error(w, "Invalid target", http.StatusBadRequest)
+		return
+	}
+
+	var event types.ChangedEvent
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxPublishBody)).Decode(&event); err != nil {
+		http.Error(w, "Invalid event", http.StatusBadRequest)
+		return
+	}
+
+	if event.Resource.ProjectId == "" && len(auth.projects) == 1 {
+		event.Resource.ProjectId = auth.projects[0]
+	}
+
+	if !slices.Contains(auth.projects, event.Resource.ProjectId) || event.Resource.ResourceId == "" {
+		http.Error(w, "Invalid event", http.StatusBadRequest)
+		return
+	}
+
+	if !s.Authorize(auth.principal, ActionPublish, event.Resource.ProjectId, event.Resource.Group) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	if event.Timestamp.IsZero() {
+		event.Timestamp = time.Now()
+	}
+
+	report, err := s.BroadcastTo(r.Context(), event, target)
+	if errors.Is(err, ErrRateLimited) {
+		writeRateLimited(w, err)
+		return
+	} else if errors.Is(err, ErrRolloutInProgress) {
+		http.Error(w, "Rollout in progress", http.StatusConflict)
+		return
+	} else if err != nil {
+		slog.Error("Failed to broadcast published event", "resource", event.Resource, "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	slog.Info("Event published", "principal", auth.principal, "resource", event.Resource, "action", event.Action, "report", report)
+	writeJSON(w, http.StatusAccepted, report)
+}
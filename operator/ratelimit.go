@@ -0,0 +1,114 @@
+package operator
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// tokenBucket refills at rate tokens per second up to burst
+type tokenBucket struct {
+	tokens   float64
+	last     time.Time
+	lastSeen time.Time
+}
+
+// rateLimiter keeps one token bucket per key, e.g. per IP or per project
+type rateLimiter struct {
+	rate  float64
+	burst float64
+	now   func() time.Time
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &rateLimiter{
+		rate:    rate,
+		burst:   float64(burst),
+		now:     time.Now,
+		buckets: make(map[string]*tokenBucket),
+	}
+}
+
+// allow takes a token for the key. When none is left it returns false and
+// how long until the next token is available.
+func (l *rateLimiter) allow(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	b.lastSeen = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+
+	wait := time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	return false, wait
+}
+
+// cleanup drops buckets idle long enough to be full again
+func (l *rateLimiter) cleanup() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	idle := time.Duration(l.burst / l.rate * float64(time.Second))
+	now := l.now()
+	for key, b := range l.buckets {
+		if now.Sub(b.lastSeen) > idle {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// connectionCounter tracks live connections per key against a maximum
+type connectionCounter struct {
+	max int
+
+	mu    sync.Mutex
+	count map[string]int
+}
+
+func newConnectionCounter(max int) *connectionCounter {
+	return &connectionCounter{max: max, count: make(map[string]int)}
+}
+
+// acquire takes a connection slot for the key, reporting false when the key
+// is at its maximum
+func (c *connectionCounter) acquire(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.count[key] >= c.max {
+		return false
+	}
+	c.count[key]++
+
+	return true
+}
+
+func (c *connectionCounter) release(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.count[key] <= 1 {
+		delete(c.count, key)
+		return
+	}
+	c.count[key]--
+}
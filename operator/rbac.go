@@ -0,0 +1,91 @@
+package operator
+
+import (
+	"path"
+	"slices"
+)
+
+type Role string
+
+const (
+	RoleViewer     Role = "viewer"
+	RoleSubscriber Role = "subscriber"
+	RolePublisher  Role = "publisher"
+	RoleAdmin      Role = "admin"
+)
+
+type Action string
+
+const (
+	ActionView      Action = "view"      // read current state and metadata
+	ActionSubscribe Action = "subscribe" // stream resource changes
+	ActionPublish   Action = "publish"   // publish resource changes
+	ActionSnapshot  Action = "snapshot"  // take snapshots of resources
+	ActionRollback  Action = "rollback"  // restore resources to a snapshot
+	ActionAdmin     Action = "admin"     // manage keys, connections and policies
+)
+
+// roleActions lists what each role may do; every role includes the ones before it
+var roleActions = map[Role][]Action{
+	RoleViewer:     {ActionView},
+	RoleSubscriber: {ActionView, ActionSubscribe},
+	RolePublisher:  {ActionView, ActionSubscribe, ActionPublish, ActionSnapshot, ActionRollback},
+	RoleAdmin:      {ActionView, ActionSubscribe, ActionPublish, ActionSnapshot, ActionRollback, ActionAdmin},
+}
+
+// Binding grants a role to the principals whose subject matches Subject, on
+// the projects matching Project and the resource groups matching Group.
+// Patterns use path.Match syntax; "*" matches everything and an empty Group
+// matches every group.
+type Binding struct {
+	Subject string `json:"subject"`
+	Project string `json:"project"`
+	Group   string `json:"group,omitempty"`
+	Role    Role   `json:"role"`
+}
+
+// Policy is a set of role bindings. Anything not granted by a binding is denied.
+type Policy struct {
+	Bindings []Binding `json:"bindings"`
+}
+
+func NewPolicy(bindings ...Binding) *Policy {
+	return &Policy{Bindings: bindings}
+}
+
+// Evaluate reports whether the principal may perform the action on a group of
+// a project. Project level actions, such as connecting or administration,
+// pass AnyGroup to be granted by a binding on any group of the project.
+func (p *Policy) Evaluate(principal *Principal, action Action, project, group string) bool {
+	if principal == nil {
+		return false
+	}
+
+	for _, binding := range p.Bindings {
+		if !slices.Contains(roleActions[binding.Role], action) {
+			continue
+		}
+
+		if !matchPattern(binding.Subject, principal.Subject) || !matchPattern(binding.Project, project) {
+			continue
+		}
+
+		if group == AnyGroup || binding.Group == "" || matchGroup(binding.Group, group) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// AnyGroup asks Policy.Evaluate whether the action is granted on any group
+const AnyGroup = "\x00any"
+
+func matchPattern(pattern, value string) bool {
+	if pattern == "*" || pattern == value {
+		return true
+	}
+
+	matched, err := path.Match(pattern, value)
+	return err == nil && matched
+}
@@ -0,0 +1,83 @@
+package operator
+
+import "testing"
+
+func TestPolicyEvaluate(t *testing.T) {
+	policy := NewPolicy(
+		Binding{Subject: "apikey:ops", Project: "*", Role: RoleAdmin},
+		Binding{Subject: "apikey:ci-*", Project: "billing", Group: "config/**", Role: RolePublisher},
+		Binding{Subject: "spiffe://corp/*", Project: "billing", Role: RoleSubscriber},
+		Binding{Subject: "*", Project: "public-*", Role: RoleViewer},
+	)
+
+	tests := []struct {
+		name    string
+		subject string
+		action  Action
+		project string
+		group   string
+		want    bool
+	}{
+		{name: "admin on any project", subject: "apikey:ops", action: ActionAdmin, project: "payroll", group: AnyGroup, want: true},
+		{name: "admin includes publish", subject: "apikey:ops", action: ActionPublish, project: "payroll", group: "config", want: true},
+		{name: "publisher in group", subject: "apikey:ci-main", action: ActionPublish, project: "billing", group: "config/db", want: true},
+		{name: "publisher outside group", subject: "apikey:ci-main", action: ActionPublish, project: "billing", group: "secrets", want: false},
+		{name: "publisher group prefix needs a segment boundary", subject: "apikey:ci-main", action: ActionPublish, project: "billing", group: "configXYZ", want: false},
+		{name: "publisher on any group", subject: "apikey:ci-main", action: ActionPublish, project: "billing", group: AnyGroup, want: true},
+		{name: "publisher on other project", subject: "apikey:ci-main", action: ActionPublish, project: "payroll", group: "config/db", want: false},
+		{name: "publisher cannot admin", subject: "apikey:ci-main", action: ActionAdmin, project: "billing", group: AnyGroup, want: false},
+		{name: "subscriber subscribes", subject: "spiffe://corp/agent", action: ActionSubscribe, project: "billing", group: "secrets", want: true},
+		{name: "subscriber cannot publish", subject: "spiffe://corp/agent", action: ActionPublish, project: "billing", group: "secrets", want: false},
+		{name: "subject pattern does not cross segments", subject: "spiffe://corp/a/b", action: ActionSubscribe, project: "billing", group: "x", want: false},
+		{name: "viewer of public projects", subject: "anyone", action: ActionView, project: "public-docs", group: "x", want: true},
+		{name: "viewer cannot subscribe", subject: "anyone", action: ActionSubscribe, project: "public-docs", group: "x", want: false},
+		{name: "unbound subject", subject: "anyone", action: ActionView, project: "billing", group: "x", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := policy.Evaluate(&Principal{Subject: tt.subject}, tt.action, tt.project, tt.group)
+			if got != tt.want {
+				t.Errorf("Evaluate(%q, %s, %q, %q) = %v, want %v", tt.subject, tt.action, tt.project, tt.group, got, tt.want)
+			}
+		})
+	}
+
+	if policy.Evaluate(nil, ActionView, "public-docs", "x") {
+		t.Error("Evaluate allowed a nil principal")
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	policy := NewPolicy(Binding{Subject: "*", Project: "*", Role: RolePublisher})
+
+	tests := []struct {
+		name      string
+		policy    *Policy
+		principal *Principal
+		action    Action
+		project   string
+		group     string
+		want      bool
+	}{
+		{name: "subscribe without policy", principal: &Principal{Subject: "a"}, action: ActionSubscribe, project: "billing", group: "config", want: true},
+		{name: "publish needs permission without policy", principal: &Principal{Subject: "a"}, action: ActionPublish, project: "billing", group: "config", want: false},
+		{name: "publish with permission", principal: &Principal{Subject: "a", Permissions: []Permission{PermissionPublish}}, action: ActionPublish, project: "billing", group: "config", want: true},
+		{name: "admin permission grants admin", principal: &Principal{Subject: "a", Permissions: []Permission{PermissionAdmin}}, action: ActionAdmin, project: "billing", group: AnyGroup, want: true},
+		{name: "principal projects apply", principal: &Principal{Subject: "a", Projects: []string{"payroll"}, Permissions: []Permission{PermissionAdmin}}, action: ActionAdmin, project: "billing", group: AnyGroup, want: false},
+		{name: "principal groups apply", principal: &Principal{Subject: "a", Groups: []string{"config/**"}}, action: ActionSubscribe, project: "billing", group: "secrets", want: false},
+		{name: "policy decides beyond scope", policy: policy, principal: &Principal{Subject: "a"}, action: ActionPublish, project: "billing", group: "config", want: true},
+		{name: "policy ignores permissions", policy: policy, principal: &Principal{Subject: "a", Permissions: []Permission{PermissionAdmin}}, action: ActionAdmin, project: "billing", group: AnyGroup, want: false},
+		{name: "policy within principal projects", policy: policy, principal: &Principal{Subject: "a", Projects: []string{"payroll"}}, action: ActionPublish, project: "billing", group: "config", want: false},
+		{name: "nil principal", action: ActionView, project: "billing", group: AnyGroup, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &SSE{policy: tt.policy}
+			if got := s.Authorize(tt.principal, tt.action, tt.project, tt.group); got != tt.want {
+				t.Errorf("Authorize = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package operator
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/lamlv2305/sentinel/types"
+)
+
+// RevokeSelector picks the live connections to revoke. Every non-empty field
+// must match; an empty selector matches nothing.
+type RevokeSelector struct {
+	KeyId     string
+	Subject   string
+	ProjectId string
+}
+
+func (rs RevokeSelector) matches(c *Client) bool {
+	if rs == (RevokeSelector{}) {
+		return false
+	}
+
+	if rs.ProjectId != "" && !c.HasProject(rs.ProjectId) {
+		return false
+	}
+
+	if c.Principal == nil {
+		return rs.KeyId == "" && rs.Subject == ""
+	}
+
+	return (rs.KeyId == "" || c.Principal.KeyId == rs.KeyId) &&
+		(rs.Subject == "" || c.Principal.Subject == rs.Subject)
+}
+
+// Revoke sends a terminal "revoked" event to every connection matching the
+// selector and closes it. It returns how many connections were revoked.
+//
+// Wire it to credential stores so revocation takes effect immediately, e.g.
+//
+//	store.OnRevoke(func(ctx context.Context, key apikey.Key) {
+//		sse.Revoke(ctx, operator.RevokeSelector{KeyId: key.Id}, "key revoked")
+//	})
+func (s *SSE) Revoke(ctx context.Context, selector RevokeSelector, reason string) int {
+	clients := s.hub.find(selector.matches)
+	for _, client := range clients {
+		s.revoke(client, reason)
+	}
+
+	return len(clients)
+}
+
+func (s *SSE) revoke(client *Client, reason string) {
+	slog.Info("Revoking client", "client", client, "reason", reason)
+	client.Terminate(controlMessage(controlEvent{Type: types.ControlRevoked, Id: client.Id, Reason: reason}))
+}
+
+// revalidate re-runs the credential verifier for every live connection and
+// revokes those whose credential is no longer valid for any of their projects
+func (s *SSE) revalidate(ctx context.Context) {
+	clients := s.hub.find(func(*Client) bool { return true })
+	for _, client := range clients {
+		// Certificates were verified at handshake; only their lifetime can lapse.
+		// Revocation through CRLs or OCSP is out of scope
+		if client.certificate != nil {
+			if time.Now().After(client.certificate.NotAfter) {
+				s.revoke(client, "client certificate expired")
+			}
+			continue
+		}
+
+		if !s.stillValid(ctx, client) {
+			s.revoke(client, "credential no longer valid")
+		}
+	}
+}
+
+func (s *SSE) stillValid(ctx context.Context, client *Client) bool {
+	for _, project := range client.Projects {
+		principal, err := s.cv(ctx, client.credential, project)
+		if err != nil || (principal != nil && !principal.AllowsProject(project)) {
+			return false
+		}
+	}
+
+	return true
+}
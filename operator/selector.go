@@ -0,0 +1,210 @@
+package operator
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var ErrInvalidSelector = errors.New("invalid selector")
+
+// Selector matches client labels. It is written as comma separated
+// requirements that must all hold:
+//
+//	region=eu         label equals value (also ==)
+//	env!=prod         label is missing or differs
+//	version<2.3       label compares below value (also <=, >, >=)
+//	canary            label is set
+//	!canary           label is not set
+//
+// Agent metadata is matched through reserved labels, e.g.
+// "agent.app=billing,agent.version>=1.4,agent.capability.ack".
+//
+// Comparisons are numeric per dot separated segment when both sides are
+// versions such as "2.10.1" or "v2.3", and lexical otherwise.
+type Selector struct {
+	requirements []requirement
+}
+
+type requirement struct {
+	key   string
+	op    string // "=", "!=", "<", "<=", ">", ">=", "exists" or "!exists"
+	value string
+}
+
+// selectorOps is ordered so that two character operators are tried first
+var selectorOps = []string{"==", "!=", "<=", ">=", "=", "<", ">"}
+
+// ParseSelector parses a selector; an empty string selects every client
+func ParseSelector(s string) (Selector, error) {
+	var sel Selector
+	for _, term := range strings.Split(s, ",") {
+		term = strings.TrimSpace(term)
+		if term == "" {
+			continue
+		}
+
+		req, err := parseRequirement(term)
+		if err != nil {
+			return Selector{}, err
+		}
+		sel.requirements = append(sel.requirements, req)
+	}
+
+	return sel, nil
+}
+
+func parseRequirement(term string) (requirement, error) {
+	at := strings.IndexAny(term, "!=<>")
+	if at < 0 {
+		return requirement{key: term, op: "exists"}, nil
+	}
+
+	if at == 0 && term[0] == '!' {
+		key := strings.TrimSpace(term[1:])
+		if key == "" || strings.ContainsAny(key, "!=<>") {
+			return requirement{}, fmt.Errorf("%w: %q", ErrInvalidSelector, term)
+		}
+		return requirement{key: key, op: "!exists"}, nil
+	}
+
+	key := strings.TrimSpace(term[:at])
+	for _, op := range selectorOps {
+		if value, ok := strings.CutPrefix(term[at:], op); ok {
+			value = strings.TrimSpace(value)
+			if key == "" || strings.ContainsAny(value, "!=<>") {
+				break
+			}
+			if op == "==" {
+				op = "="
+			}
+			return requirement{key: key, op: op, value: value}, nil
+		}
+	}
+
+	return requirement{}, fmt.Errorf("%w: %q", ErrInvalidSelector, term)
+}
+
+// IsZero reports whether the selector selects every client
+func (s Selector) IsZero() bool {
+	return len(s.requirements) == 0
+}
+
+// Matches reports whether the labels satisfy every requirement
+func (s Selector) Matches(labels map[string]string) bool {
+	for _, req := range s.requirements {
+		if !req.matches(labels) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// String formats the selector in the syntax ParseSelector accepts
+func (s Selector) String() string {
+	terms := make([]string, len(s.requirements))
+	for i, req := range s.requirements {
+		switch req.op {
+		case "exists":
+			terms[i] = req.key
+		case "!exists":
+			terms[i] = "!" + req.key
+		default:
+			terms[i] = req.key + req.op + req.value
+		}
+	}
+
+	return strings.Join(terms, ",")
+}
+
+// equality returns a requirement the label index can answer, if any
+func (s Selector) equality() (key, value string, ok bool) {
+	for _, req := range s.requirements {
+		if req.op == "=" {
+			return req.key, req.value, true
+		}
+	}
+
+	return "", "", false
+}
+
+func (r requirement) matches(labels map[string]string) bool {
+	value, ok := labels[r.key]
+	switch r.op {
+	case "exists":
+		return ok
+	case "!exists":
+		return !ok
+	case "=":
+		return ok && value == r.value
+	case "!=":
+		return !ok || value != r.value
+	}
+
+	if !ok {
+		return false
+	}
+
+	c := compareLabel(value, r.value)
+	switch r.op {
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	case ">=":
+		return c >= 0
+	}
+
+	return false
+}
+
+// compareLabel compares versions segment by segment, other values lexically
+func compareLabel(a, b string) int {
+	as, aok := versionSegments(a)
+	bs, bok := versionSegments(b)
+	if !aok || !bok {
+		return strings.Compare(a, b)
+	}
+
+	for i := 0; i < max(len(as), len(bs)); i++ {
+		var x, y uint64
+		if i < len(as) {
+			x = as[i]
+		}
+		if i < len(bs) {
+			y = bs[i]
+		}
+
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+	}
+
+	return 0
+}
+
+func versionSegments(s string) ([]uint64, bool) {
+	s = strings.TrimPrefix(s, "v")
+	if s == "" {
+		return nil, false
+	}
+
+	parts := strings.Split(s, ".")
+	segments := make([]uint64, len(parts))
+	for i, part := range parts {
+		n, err := strconv.ParseUint(part, 10, 64)
+		if err != nil {
+			return nil, false
+		}
+		segments[i] = n
+	}
+
+	return segments, true
+}
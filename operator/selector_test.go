@@ -0,0 +1,133 @@
+package operator
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseSelector(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []requirement
+		str  string
+		err  error
+	}{
+		{in: "", str: ""},
+		{in: " , ", str: ""},
+		{in: "region=eu", want: []requirement{{key: "region", op: "=", value: "eu"}}, str: "region=eu"},
+		{in: "region==eu", want: []requirement{{key: "region", op: "=", value: "eu"}}, str: "region=eu"},
+		{in: "env!=prod", want: []requirement{{key: "env", op: "!=", value: "prod"}}, str: "env!=prod"},
+		{in: "agent.version<2.3", want: []requirement{{key: "agent.version", op: "<", value: "2.3"}}, str: "agent.version<2.3"},
+		{in: "agent.version<=2.3", want: []requirement{{key: "agent.version", op: "<=", value: "2.3"}}, str: "agent.version<=2.3"},
+		{in: "agent.version>2.3", want: []requirement{{key: "agent.version", op: ">", value: "2.3"}}, str: "agent.version>2.3"},
+		{in: "agent.version>=2.3", want: []requirement{{key: "agent.version", op: ">=", value: "2.3"}}, str: "agent.version>=2.3"},
+		{in: "canary", want: []requirement{{key: "canary", op: "exists"}}, str: "canary"},
+		{in: "!canary", want: []requirement{{key: "canary", op: "!exists"}}, str: "!canary"},
+		{in: "region = eu , !canary,tier", want: []requirement{{key: "region", op: "=", value: "eu"}, {key: "canary", op: "!exists"}, {key: "tier", op: "exists"}}, str: "region=eu,!canary,tier"},
+		{in: "region=", want: []requirement{{key: "region", op: "=", value: ""}}, str: "region="},
+		{in: "=eu", err: ErrInvalidSelector},
+		{in: "!", err: ErrInvalidSelector},
+		{in: "!a=b", err: ErrInvalidSelector},
+		{in: "a=<b", err: ErrInvalidSelector},
+		{in: "a=>b", err: ErrInvalidSelector},
+		{in: "a!b", err: ErrInvalidSelector},
+		{in: "a=b=c", err: ErrInvalidSelector},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			sel, err := ParseSelector(tt.in)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("error = %v, want %v", err, tt.err)
+			}
+			if tt.err != nil {
+				return
+			}
+
+			if len(sel.requirements) != len(tt.want) {
+				t.Fatalf("requirements = %+v, want %+v", sel.requirements, tt.want)
+			}
+			for i := range tt.want {
+				if sel.requirements[i] != tt.want[i] {
+					t.Errorf("requirement %d = %+v, want %+v", i, sel.requirements[i], tt.want[i])
+				}
+			}
+
+			if got := sel.String(); got != tt.str {
+				t.Errorf("String = %q, want %q", got, tt.str)
+			}
+			if sel.IsZero() != (len(tt.want) == 0) {
+				t.Errorf("IsZero = %v", sel.IsZero())
+			}
+		})
+	}
+}
+
+func TestSelectorMatches(t *testing.T) {
+	labels := map[string]string{"region": "eu", "agent.version": "2.10.1", "canary": "true", "tier": "gold"}
+
+	tests := []struct {
+		selector string
+		want     bool
+	}{
+		{selector: "", want: true},
+		{selector: "region=eu", want: true},
+		{selector: "region=us", want: false},
+		{selector: "zone=eu", want: false},
+		{selector: "region!=us", want: true},
+		{selector: "region!=eu", want: false},
+		{selector: "zone!=eu", want: true},
+		{selector: "agent.version>2.9", want: true},
+		{selector: "agent.version<2.9", want: false},
+		{selector: "agent.version>=2.10.1", want: true},
+		{selector: "agent.version<=v2.10.1", want: true},
+		{selector: "agent.version<2.10.1", want: false},
+		{selector: "missing>1", want: false},
+		{selector: "missing<1", want: false},
+		{selector: "tier>silver", want: false},
+		{selector: "tier<silver", want: true},
+		{selector: "canary", want: true},
+		{selector: "!canary", want: false},
+		{selector: "!zone", want: true},
+		{selector: "region=eu,canary,agent.version>=2", want: true},
+		{selector: "region=eu,!canary", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.selector, func(t *testing.T) {
+			sel, err := ParseSelector(tt.selector)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := sel.Matches(labels); got != tt.want {
+				t.Errorf("Matches = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompareLabel(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{a: "2.10", b: "2.9", want: 1},
+		{a: "2.9", b: "2.10", want: -1},
+		{a: "v2.3", b: "2.3", want: 0},
+		{a: "2.3", b: "2.3.0", want: 0},
+		{a: "2.3.1", b: "2.3", want: 1},
+		{a: "10", b: "9", want: 1},
+		{a: "1.4", b: "v1.10", want: -1},
+		// Not versions on both sides, so lexical
+		{a: "2.10-rc1", b: "2.9", want: -1},
+		{a: "beta", b: "alpha", want: 1},
+		{a: "v", b: "1", want: 1},
+		{a: "", b: "1", want: -1},
+	}
+
+	for _, tt := range tests {
+		if got := compareLabel(tt.a, tt.b); got != tt.want {
+			t.Errorf("compareLabel(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,487 @@
+package operator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lamlv2305/sentinel/types"
+)
+
+var (
+	ErrInvalidStrategy   = errors.New("invalid rollout strategy")
+	ErrRolloutInProgress = errors.New("rollout in progress")
+	ErrRolloutNotFound   = errors.New("rollout not found")
+	ErrRolloutFinished   = errors.New("rollout finished")
+)
+
+// cohortBuckets is how finely agents are split into cohorts
+const cohortBuckets = 10000
+
+// RolloutPhase is where a staged rollout stands
+type RolloutPhase string
+
+const (
+	RolloutInProgress RolloutPhase = "in_progress"
+	RolloutPaused     RolloutPhase = "paused"
+	RolloutCompleted  RolloutPhase = "completed"
+	RolloutAborted    RolloutPhase = "aborted"
+)
+
+// RolloutStrategy describes how a staged rollout progresses
+type RolloutStrategy struct {
+	// Stages are the percentages of agents each stage reaches, increasing
+	// and ending with 100, e.g. 1, 10, 100
+	Stages []float64
+
+	// Interval promotes a stage automatically once it ran that long without
+	// being paused; zero leaves promotion to PromoteRollout
+	Interval time.Duration
+
+	// FailureThreshold is the fraction of acks reporting a rejected or
+	// failed version above which the rollout pauses; zero pauses on the
+	// first one
+	FailureThreshold float64
+
+	// Selector narrows the rollout to the agents it matches
+	Selector Selector
+}
+
+func (rs RolloutStrategy) validate() error {
+	if len(rs.Stages) == 0 || rs.Stages[len(rs.Stages)-1] != 100 {
+		return fmt.Errorf("%w: stages must end with 100", ErrInvalidStrategy)
+	}
+
+	for i, percent := range rs.Stages {
+		if percent <= 0 || (i > 0 && percent <= rs.Stages[i-1]) {
+			return fmt.Errorf("%w: stages must be increasing percentages", ErrInvalidStrategy)
+		}
+	}
+
+	if rs.FailureThreshold < 0 || rs.FailureThreshold > 1 {
+		return fmt.Errorf("%w: failure threshold must be within [0, 1]", ErrInvalidStrategy)
+	}
+
+	return nil
+}
+
+// StagedRollout describes a staged rollout
+type StagedRollout struct {
+	Id         string       `json:"id"`
+	ProjectId  string       `json:"project_id"`
+	ResourceId string       `json:"resource_id"`
+	Version    string       `json:"version"`
+	Previous   string       `json:"previous,omitempty"` // version restored on abort, if any
+	Stage      int          `json:"stage"`
+	Percent    float64      `json:"percent"`
+	Phase      RolloutPhase `json:"phase"`
+	Reason     string       `json:"reason,omitempty"` // why the rollout was paused or aborted
+	Sent       int          `json:"sent"`             // connections the version was sent to
+	StartedAt  time.Time    `json:"started_at"`
+	StageAt    time.Time    `json:"stage_at"` // when the current stage began
+}
+
+type stagedRollout struct {
+	id        string
+	event     types.ChangedEvent
+	previous  *types.ChangedEvent
+	strategy  RolloutStrategy
+	startedAt time.Time
+
+	mu      sync.Mutex
+	stage   int
+	stageAt time.Time
+	phase   RolloutPhase
+	reason  string
+	sent    map[string]struct{} // connection ids the version was sent to
+	changed chan struct{}       // signaled on promote and abort
+
+	failures  int // failed or rejected acks seen so far
+	tolerated int // failures accepted by the last promotion
+}
+
+// rolloutController keeps the staged rollouts, one per resource
+type rolloutController struct {
+	mu       sync.Mutex
+	rollouts map[string]*stagedRollout // by id
+}
+
+func newRolloutController() *rolloutController {
+	return &rolloutController{rollouts: make(map[string]*stagedRollout)}
+}
+
+// active reports whether a staged rollout of the resource is in progress or
+// paused
+func (rc *rolloutController) active(projectId, resourceId string) bool {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	for _, r := range rc.rollouts {
+		if r.event.Resource.ProjectId != projectId || r.event.Resource.ResourceId != resourceId {
+			continue
+		}
+
+		r.mu.Lock()
+		active := r.active()
+		r.mu.Unlock()
+		if active {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (r *stagedRollout) active() bool {
+	return r.phase == RolloutInProgress || r.phase == RolloutPaused
+}
+
+func (r *stagedRollout) describe() StagedRollout {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	described := StagedRollout{
+		Id:         r.id,
+		ProjectId:  r.event.Resource.ProjectId,
+		ResourceId: r.event.Resource.ResourceId,
+		Version:    r.event.Resource.Version,
+		Stage:      r.stage,
+		Percent:    r.strategy.Stages[r.stage],
+		Phase:      r.phase,
+		Reason:     r.reason,
+		Sent:       len(r.sent),
+		StartedAt:  r.startedAt,
+		StageAt:    r.stageAt,
+	}
+	if r.previous != nil {
+		described.Previous = r.previous.Resource.Version
+	}
+
+	return described
+}
+
+// inCohort reports whether the client is among the given percentage of
+// agents. Agents are identified by hostname and app, or by principal, so an
+// agent stays in its cohort when it reconnects.
+func (r *stagedRollout) inCohort(c *Client, percent float64) bool {
+	if !c.HasProject(r.event.Resource.ProjectId) || !r.strategy.Selector.Matches(c.selectable) {
+		return false
+	}
+
+	h := fnv.New64a()
+	h.Write([]byte(r.event.Resource.ProjectId + "/" + r.event.Resource.ResourceId + "/" + c.identity()))
+
+	return float64(h.Sum64()%cohortBuckets) < percent*cohortBuckets/100
+}
+
+// StartRollout delivers a new version of a resource in stages, to a growing
+// cohort of agents. Agents outside the current stage keep the previous
+// version, which is also sent to agents connecting outside of it and restored
+// by AbortRollout; previous may be nil. Acks must be tracked, see
+// WithSSEAckEndpoint, so failures reported by agents pause the rollout.
+// Until it completes or is aborted, broadcasts of the resource fail with
+// ErrRolloutInProgress.
+func (s *SSE) StartRollout(ctx context.Context, event types.ChangedEvent, previous *types.ChangedEvent, strategy RolloutStrategy) (StagedRollout, error) {
+	if s.acks == nil {
+		return StagedRollout{}, ErrAcksNotTracked
+	}
+
+	if err := strategy.validate(); err != nil {
+		return StagedRollout{}, err
+	}
+
+	if err := s.allowPublish(event.Resource.ProjectId); err != nil {
+		return StagedRollout{}, err
+	}
+
+	if event.Resource.Version == "" {
+		event.Resource.Version = uuid.New().String()
+	}
+	if event.Timestamp.IsZero() {
+		event.Timestamp = time.Now()
+	}
+	if previous != nil && previous.Resource.Version == "" {
+		pinned := *previous
+		pinned.Resource.Version = uuid.New().String()
+		previous = &pinned
+	}
+
+	r := &stagedRollout{
+		id:        uuid.New().String(),
+		event:     event,
+		previous:  previous,
+		strategy:  strategy,
+		startedAt: time.Now(),
+		stageAt:   time.Now(),
+		phase:     RolloutInProgress,
+		sent:      make(map[string]struct{}),
+		changed:   make(chan struct{}, 1),
+	}
+
+	s.rollouts.mu.Lock()
+	for id, other := range s.rollouts.rollouts {
+		if other.event.Resource.ProjectId != event.Resource.ProjectId || other.event.Resource.ResourceId != event.Resource.ResourceId {
+			continue
+		}
+
+		other.mu.Lock()
+		active := other.active()
+		other.mu.Unlock()
+		if active {
+			s.rollouts.mu.Unlock()
+			return StagedRollout{}, fmt.Errorf("%w: %s", ErrRolloutInProgress, id)
+		}
+		delete(s.rollouts.rollouts, id)
+	}
+	s.rollouts.rollouts[r.id] = r
+	s.rollouts.mu.Unlock()
+
+	// Stages and late joiners are sent without tracking the version again,
+	// so it stays the latest even while the previous one is restored
+	s.acks.publishing(event.Resource)
+
+	r.mu.Lock()
+	err := s.deliverStage(ctx, r)
+	r.mu.Unlock()
+	if err != nil {
+		s.rollouts.mu.Lock()
+		delete(s.rollouts.rollouts, r.id)
+		s.rollouts.mu.Unlock()
+		return StagedRollout{}, err
+	}
+
+	slog.Info("Rollout started", "rollout", r.id, "resource", event.Resource, "stages", strategy.Stages)
+	go s.watchRollout(context.WithoutCancel(ctx), r)
+
+	return r.describe(), nil
+}
+
+// PromoteRollout moves the rollout to its next stage, resuming it if it was
+// paused
+func (s *SSE) PromoteRollout(ctx context.Context, id string) (StagedRollout, error) {
+	r, err := s.findRollout(id)
+	if err != nil {
+		return StagedRollout{}, err
+	}
+
+	r.mu.Lock()
+	err = s.promote(ctx, r)
+	r.mu.Unlock()
+	if err != nil {
+		return StagedRollout{}, err
+	}
+
+	signal(r.changed)
+	return r.describe(), nil
+}
+
+// AbortRollout stops the rollout and sends the previous version, if any, to
+// the connections that got the new one
+func (s *SSE) AbortRollout(ctx context.Context, id, reason string) (StagedRollout, error) {
+	r, err := s.findRollout(id)
+	if err != nil {
+		return StagedRollout{}, err
+	}
+
+	r.mu.Lock()
+	if !r.active() {
+		r.mu.Unlock()
+		return StagedRollout{}, ErrRolloutFinished
+	}
+
+	r.phase = RolloutAborted
+	r.reason = reason
+	ids := make([]string, 0, len(r.sent))
+	for connectionId := range r.sent {
+		ids = append(ids, connectionId)
+	}
+	r.mu.Unlock()
+
+	slog.Warn("Rollout aborted", "rollout", r.id, "resource", r.event.Resource, "reason", reason)
+	signal(r.changed)
+
+	if r.previous != nil && len(ids) > 0 {
+		if _, err := s.send(ctx, *r.previous, Target{ConnectionIds: ids}); err != nil {
+			return r.describe(), fmt.Errorf("failed to restore previous version: %w", err)
+		}
+	}
+
+	return r.describe(), nil
+}
+
+// Rollouts returns the staged rollouts, the latest of each resource
+func (s *SSE) Rollouts() []StagedRollout {
+	s.rollouts.mu.Lock()
+	rollouts := make([]*stagedRollout, 0, len(s.rollouts.rollouts))
+	for _, r := range s.rollouts.rollouts {
+		rollouts = append(rollouts, r)
+	}
+	s.rollouts.mu.Unlock()
+
+	described := make([]StagedRollout, len(rollouts))
+	for i, r := range rollouts {
+		described[i] = r.describe()
+	}
+
+	return described
+}
+
+// Rollout returns a staged rollout
+func (s *SSE) Rollout(id string) (StagedRollout, error) {
+	r, err := s.findRollout(id)
+	if err != nil {
+		return StagedRollout{}, err
+	}
+
+	return r.describe(), nil
+}
+
+func (s *SSE) findRollout(id string) (*stagedRollout, error) {
+	s.rollouts.mu.Lock()
+	defer s.rollouts.mu.Unlock()
+
+	r, ok := s.rollouts.rollouts[id]
+	if !ok {
+		return nil, ErrRolloutNotFound
+	}
+
+	return r, nil
+}
+
+// promote moves to the next stage; r.mu must be held
+func (s *SSE) promote(ctx context.Context, r *stagedRollout) error {
+	if !r.active() {
+		return ErrRolloutFinished
+	}
+
+	if r.stage < len(r.strategy.Stages)-1 {
+		r.stage++
+	}
+	r.stageAt = time.Now()
+	r.phase = RolloutInProgress
+	r.reason = ""
+	r.tolerated = r.failures
+
+	slog.Info("Rollout promoted", "rollout", r.id, "percent", r.strategy.Stages[r.stage])
+	return s.deliverStage(ctx, r)
+}
+
+// deliverStage sends the version to the connections of the current stage
+// that do not have it yet, completing the rollout at its last stage; r.mu
+// must be held
+func (s *SSE) deliverStage(ctx context.Context, r *stagedRollout) error {
+	percent := r.strategy.Stages[r.stage]
+	clients := s.hub.find(func(c *Client) bool {
+		_, sent := r.sent[c.Id]
+		return !sent && r.inCohort(c, percent)
+	})
+
+	if len(clients) > 0 {
+		ids := make([]string, len(clients))
+		for i, client := range clients {
+			ids[i] = client.Id
+		}
+
+		if _, err := s.send(ctx, r.event, Target{ConnectionIds: ids}); err != nil {
+			return fmt.Errorf("failed to deliver rollout stage: %w", err)
+		}
+		for _, id := range ids {
+			r.sent[id] = struct{}{}
+		}
+	}
+
+	if r.stage == len(r.strategy.Stages)-1 && r.phase == RolloutInProgress {
+		r.phase = RolloutCompleted
+		slog.Info("Rollout completed", "rollout", r.id, "resource", r.event.Resource)
+	}
+
+	return nil
+}
+
+// watchRollout pauses the rollout when agents report too many failures, and
+// promotes it once its stage interval elapsed
+func (s *SSE) watchRollout(ctx context.Context, r *stagedRollout) {
+	resource := r.event.Resource
+	for {
+		status, updated, tracked := s.acks.watch(resource.ProjectId, resource.ResourceId, resource.Version)
+
+		r.mu.Lock()
+		if !r.active() {
+			r.mu.Unlock()
+			return
+		}
+
+		failures := len(status.Rejected) + len(status.Failed)
+		acked := len(status.Applied) + failures
+		r.failures = failures
+		if r.phase == RolloutInProgress && failures > r.tolerated && float64(failures) > r.strategy.FailureThreshold*float64(acked) {
+			r.phase = RolloutPaused
+			r.reason = fmt.Sprintf("%d of %d agents failed to apply the version", failures, acked)
+			slog.Warn("Rollout paused", "rollout", r.id, "resource", resource, "reason", r.reason)
+		}
+
+		timer := time.NewTimer(time.Until(r.stageAt.Add(r.strategy.Interval)))
+		if r.phase != RolloutInProgress || r.strategy.Interval == 0 {
+			timer.Stop()
+		}
+		r.mu.Unlock()
+
+		if !tracked {
+			updated = nil
+		}
+
+		select {
+		case <-updated:
+		case <-r.changed:
+		case <-timer.C:
+			r.mu.Lock()
+			if r.phase == RolloutInProgress && time.Since(r.stageAt) >= r.strategy.Interval {
+				if err := s.promote(ctx, r); err != nil {
+					slog.Error("Failed to promote rollout", "rollout", r.id, "error", err)
+				}
+			}
+			r.mu.Unlock()
+		}
+		timer.Stop()
+	}
+}
+
+// rolloutConnected keeps a connecting client on the version of its cohort:
+// the new version of a rollout whose current stage includes it, or else the
+// previous one
+func (s *SSE) rolloutConnected(ctx context.Context, client *Client) {
+	s.rollouts.mu.Lock()
+	rollouts := make([]*stagedRollout, 0, len(s.rollouts.rollouts))
+	for _, r := range s.rollouts.rollouts {
+		rollouts = append(rollouts, r)
+	}
+	s.rollouts.mu.Unlock()
+
+	for _, r := range rollouts {
+		r.mu.Lock()
+		if !r.active() || !client.HasProject(r.event.Resource.ProjectId) {
+			r.mu.Unlock()
+			continue
+		}
+
+		event := r.previous
+		if r.inCohort(client, r.strategy.Stages[r.stage]) {
+			event = &r.event
+			r.sent[client.Id] = struct{}{}
+		}
+		r.mu.Unlock()
+
+		if event == nil {
+			continue
+		}
+
+		if _, err := s.send(ctx, *event, Target{ConnectionIds: []string{client.Id}}); err != nil {
+			slog.Error("Failed to send rollout version to client", "rollout", r.id, "client", client, "error", err)
+		}
+	}
+}
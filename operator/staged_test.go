@@ -0,0 +1,356 @@
+package operator
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/goccy/go-json"
+	"github.com/lamlv2305/sentinel/types"
+)
+
+func TestStagedRolloutSend(t *testing.T) {
+	// Budget for the previous version and the start of the rollout only
+	s := NewSSE(http.NewServeMux(), "/sse",
+		WithSSEAckEndpoint("/ack"),
+		WithSSELimits(Limits{PublishRate: 1e-6, PublishBurst: 2}),
+	)
+
+	for i := range 20 {
+		client := NewClient(fmt.Sprintf("c%d", i), "billing")
+		client.Metadata.Hostname = fmt.Sprintf("host-%d", i)
+		client.Metadata.Capabilities = []string{types.CapabilityAck}
+		s.hub.add(client)
+	}
+
+	ctx := context.Background()
+	previous := types.ChangedEvent{Resource: types.Resource{ProjectId: "billing", ResourceId: "config", Version: "v1"}}
+	if _, err := s.Broadcast(ctx, previous); err != nil {
+		t.Fatal(err)
+	}
+
+	event := types.ChangedEvent{Resource: types.Resource{ProjectId: "billing", ResourceId: "config", Version: "v2"}}
+	rollout, err := s.StartRollout(ctx, event, &previous, RolloutStrategy{Stages: []float64{50, 100}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rollout.Sent == 0 {
+		t.Fatal("first stage reached no client")
+	}
+
+	if _, err := s.Broadcast(ctx, event); !errors.Is(err, ErrRolloutInProgress) {
+		t.Errorf("broadcast during the rollout: error = %v, want %v", err, ErrRolloutInProgress)
+	}
+
+	// Late joiners and restoring the previous version spend no budget
+	late := NewClient("late", "billing")
+	late.Metadata.Hostname = "host-late"
+	s.hub.add(late)
+	s.rolloutConnected(ctx, late)
+
+	if _, err := s.AbortRollout(ctx, rollout.Id, "test"); err != nil {
+		t.Fatalf("abort: %v", err)
+	}
+
+	status, ok := s.RolloutStatus("billing", "config", "")
+	if !ok || status.Version != "v2" {
+		t.Errorf("latest tracked version = %q, want v2", status.Version)
+	}
+
+	if _, err := s.Broadcast(ctx, event); errors.Is(err, ErrRolloutInProgress) {
+		t.Errorf("broadcast after the rollout: error = %v", err)
+	}
+}
+
+// rolloutClients adds ack-capable clients of the billing project, each on its
+// own host
+func rolloutClients(s *SSE, n int) []*Client {
+	clients := make([]*Client, n)
+	for i := range clients {
+		client := NewClient(fmt.Sprintf("c%d", i), "billing")
+		client.Principal = &Principal{Subject: fmt.Sprintf("agent-%d", i)}
+		client.Metadata.Hostname = fmt.Sprintf("host-%d", i)
+		client.Metadata.Capabilities = []string{types.CapabilityAck}
+		s.hub.add(client)
+		clients[i] = client
+	}
+
+	return clients
+}
+
+// received drains the client's outbox, returning the versions it was sent
+func received(t *testing.T, c *Client) []string {
+	t.Helper()
+
+	var versions []string
+	for _, msg := range c.outbox.take() {
+		data, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(msg.data, "data: "))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var event types.ChangedEvent
+		if err := json.Unmarshal(data, &event); err != nil {
+			t.Fatal(err)
+		}
+		versions = append(versions, event.Resource.Version)
+	}
+
+	return versions
+}
+
+// waitPhase waits for the rollout to reach the phase
+func waitPhase(t *testing.T, s *SSE, id string, phase RolloutPhase) StagedRollout {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		rollout, err := s.Rollout(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rollout.Phase == phase {
+			return rollout
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("rollout is %s, want %s", rollout.Phase, phase)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// sentIds returns the connections the rollout sent its version to
+func sentIds(t *testing.T, s *SSE, id string) []string {
+	t.Helper()
+
+	r, err := s.findRollout(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return slices.Sorted(maps.Keys(r.sent))
+}
+
+func TestRolloutCohort(t *testing.T) {
+	r := &stagedRollout{event: types.ChangedEvent{Resource: types.Resource{ProjectId: "billing", ResourceId: "config"}}}
+	stages := []float64{1, 10, 25, 50, 100}
+
+	counts := make([]int, len(stages))
+	for i := range 1000 {
+		client := NewClient("first", "billing")
+		client.Metadata.Hostname = fmt.Sprintf("host-%d", i)
+		client.Metadata.App = "api"
+
+		// The same agent reconnecting under a new connection id
+		reconnected := NewClient("second", "billing")
+		reconnected.Metadata = client.Metadata
+
+		in := false
+		for j, percent := range stages {
+			got := r.inCohort(client, percent)
+			if got != r.inCohort(reconnected, percent) {
+				t.Fatalf("%s changed cohort on reconnect at %v%%", client.Metadata.Hostname, percent)
+			}
+			if in && !got {
+				t.Fatalf("%s left the cohort going to %v%%", client.Metadata.Hostname, percent)
+			}
+			in = got
+			if got {
+				counts[j]++
+			}
+		}
+	}
+
+	if counts[len(counts)-1] != 1000 {
+		t.Errorf("%d agents at 100%%, want all", counts[len(counts)-1])
+	}
+	for i, count := range counts[:len(counts)-1] {
+		if want := stages[i] * 10; float64(count) < want/2 || float64(count) > want*2 {
+			t.Errorf("%d agents at %v%%, want about %v", count, stages[i], want)
+		}
+	}
+
+	// Agents without a hostname are identified by principal
+	a := NewClient("a", "billing")
+	a.Principal = &Principal{Subject: "agent"}
+	b := NewClient("b", "billing")
+	b.Principal = &Principal{Subject: "agent"}
+	for _, percent := range stages {
+		if r.inCohort(a, percent) != r.inCohort(b, percent) {
+			t.Errorf("principal changed cohort at %v%%", percent)
+		}
+	}
+
+	if r.inCohort(NewClient("other", "payroll"), 100) {
+		t.Error("client of another project in the cohort")
+	}
+}
+
+func TestRolloutPauseOnFailures(t *testing.T) {
+	ctx := context.Background()
+	s := NewSSE(http.NewServeMux(), "/sse", WithSSEAckEndpoint("/ack"))
+	rolloutClients(s, 100)
+
+	event := types.ChangedEvent{Resource: types.Resource{ProjectId: "billing", ResourceId: "config", Version: "v2"}}
+	rollout, err := s.StartRollout(ctx, event, nil, RolloutStrategy{Stages: []float64{10, 50, 100}, FailureThreshold: 0.4})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sent := sentIds(t, s, rollout.Id)
+	if len(sent) < 4 {
+		t.Fatalf("first stage reached %d clients, want at least 4", len(sent))
+	}
+
+	ack := func(connectionId string, status types.AckStatus) {
+		s.acks.record(types.Ack{ConnectionId: connectionId, ProjectId: "billing", ResourceId: "config", Version: "v2", Status: status}, nil, "")
+	}
+
+	// One failure out of three acks stays under the threshold
+	ack(sent[0], types.AckApplied)
+	ack(sent[1], types.AckApplied)
+	ack(sent[2], types.AckFailed)
+	time.Sleep(50 * time.Millisecond)
+	if got, _ := s.Rollout(rollout.Id); got.Phase != RolloutInProgress {
+		t.Fatalf("rollout %s under the threshold", got.Phase)
+	}
+
+	ack(sent[3], types.AckRejected)
+	paused := waitPhase(t, s, rollout.Id, RolloutPaused)
+	if paused.Reason == "" {
+		t.Error("paused without a reason")
+	}
+
+	// Promoting accepts the failures seen so far
+	promoted, err := s.PromoteRollout(ctx, rollout.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if promoted.Phase != RolloutInProgress || promoted.Percent != 50 || promoted.Sent <= len(sent) {
+		t.Fatalf("promoted = %+v, want the 50%% stage in progress", promoted)
+	}
+
+	more := sentIds(t, s, rollout.Id)
+	fresh := slices.DeleteFunc(more, func(id string) bool { return slices.Contains(sent, id) })
+
+	ack(fresh[0], types.AckApplied)
+	time.Sleep(50 * time.Millisecond)
+	if got, _ := s.Rollout(rollout.Id); got.Phase != RolloutInProgress {
+		t.Fatalf("rollout %s on tolerated failures", got.Phase)
+	}
+
+	ack(fresh[1], types.AckFailed)
+	waitPhase(t, s, rollout.Id, RolloutPaused)
+}
+
+func TestRolloutInterval(t *testing.T) {
+	s := NewSSE(http.NewServeMux(), "/sse", WithSSEAckEndpoint("/ack"))
+	clients := rolloutClients(s, 20)
+
+	event := types.ChangedEvent{Resource: types.Resource{ProjectId: "billing", ResourceId: "config", Version: "v2"}}
+	rollout, err := s.StartRollout(context.Background(), event, nil, RolloutStrategy{Stages: []float64{25, 50, 100}, Interval: 20 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	completed := waitPhase(t, s, rollout.Id, RolloutCompleted)
+	if completed.Stage != 2 || completed.Sent != len(clients) {
+		t.Errorf("completed = %+v, want every client at the last stage", completed)
+	}
+
+	for _, client := range clients {
+		if got := received(t, client); !slices.Equal(got, []string{"v2"}) {
+			t.Errorf("%s received %v, want v2 once", client.Id, got)
+		}
+	}
+
+	if _, err := s.PromoteRollout(context.Background(), rollout.Id); !errors.Is(err, ErrRolloutFinished) {
+		t.Errorf("promote completed: error = %v, want %v", err, ErrRolloutFinished)
+	}
+}
+
+func TestRolloutAbortAndConnect(t *testing.T) {
+	ctx := context.Background()
+	s := NewSSE(http.NewServeMux(), "/sse", WithSSEAckEndpoint("/ack"))
+	clients := rolloutClients(s, 20)
+
+	previous := types.ChangedEvent{Resource: types.Resource{ProjectId: "billing", ResourceId: "config", Version: "v1"}}
+	event := types.ChangedEvent{Resource: types.Resource{ProjectId: "billing", ResourceId: "config", Version: "v2"}}
+	rollout, err := s.StartRollout(ctx, event, &previous, RolloutStrategy{Stages: []float64{50, 100}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sent := sentIds(t, s, rollout.Id)
+	for _, client := range clients {
+		want := []string(nil)
+		if slices.Contains(sent, client.Id) {
+			want = []string{"v2"}
+		}
+		if got := received(t, client); !slices.Equal(got, want) {
+			t.Errorf("%s received %v, want %v", client.Id, got, want)
+		}
+	}
+
+	// Agents connecting during the rollout get the version of their cohort
+	r, err := s.findRollout(rollout.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	joined := map[bool]*Client{}
+	for i := 0; len(joined) < 2; i++ {
+		client := NewClient(fmt.Sprintf("late-%d", i), "billing")
+		client.Principal = &Principal{Subject: fmt.Sprintf("late-agent-%d", i)}
+		client.Metadata.Hostname = fmt.Sprintf("late-host-%d", i)
+		in := r.inCohort(client, 50)
+		if _, ok := joined[in]; !ok {
+			joined[in] = client
+		}
+	}
+	for in, client := range joined {
+		s.hub.add(client)
+		s.rolloutConnected(ctx, client)
+
+		want := "v1"
+		if in {
+			want = "v2"
+		}
+		if got := received(t, client); !slices.Equal(got, []string{want}) {
+			t.Errorf("late client in cohort %v received %v, want %s", in, got, want)
+		}
+	}
+	sent = append(sent, joined[true].Id)
+
+	aborted, err := s.AbortRollout(ctx, rollout.Id, "bad config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if aborted.Phase != RolloutAborted || aborted.Reason != "bad config" {
+		t.Errorf("aborted = %+v", aborted)
+	}
+
+	// Only connections that got the new version are restored
+	for _, client := range append(clients, joined[false]) {
+		want := []string(nil)
+		if slices.Contains(sent, client.Id) {
+			want = []string{"v1"}
+		}
+		if got := received(t, client); !slices.Equal(got, want) {
+			t.Errorf("%s received %v after abort, want %v", client.Id, got, want)
+		}
+	}
+
+	if _, err := s.AbortRollout(ctx, rollout.Id, "again"); !errors.Is(err, ErrRolloutFinished) {
+		t.Errorf("abort twice: error = %v, want %v", err, ErrRolloutFinished)
+	}
+}
@@ -0,0 +1,78 @@
+package operator
+
+import (
+	"errors"
+	"net/http"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/lamlv2305/sentinel/types"
+)
+
+var ErrInvalidSubscription = errors.New("invalid subscription")
+
+// Subscription narrows the events a client receives within its project.
+// Each non-empty list must match for an event to be delivered.
+type Subscription struct {
+	// Groups are group patterns: exact names, globs such as "payments/*",
+	// or prefixes such as "payments/**"
+	Groups      []string             `json:"groups,omitempty"`
+	ResourceIds []string             `json:"resource_ids,omitempty"`
+	Types       []types.ResourceType `json:"types,omitempty"`
+}
+
+// Matches reports whether the resource is part of the subscription
+func (s Subscription) Matches(r types.Resource) bool {
+	if len(s.Groups) > 0 && !slices.ContainsFunc(s.Groups, func(pattern string) bool {
+		return matchGroup(pattern, r.Group)
+	}) {
+		return false
+	}
+
+	if len(s.ResourceIds) > 0 && !slices.Contains(s.ResourceIds, r.ResourceId) {
+		return false
+	}
+
+	if len(s.Types) > 0 && !slices.Contains(s.Types, r.ResourceType) {
+		return false
+	}
+
+	return true
+}
+
+// parseSubscription reads the subscription of a connecting client from the
+// group, resource and type query parameters, each repeatable or comma
+// separated
+func parseSubscription(r *http.Request) (Subscription, error) {
+	query := r.URL.Query()
+	sub := Subscription{
+		Groups:      queryList(query["group"]),
+		ResourceIds: queryList(query["resource"]),
+	}
+
+	for _, resourceType := range queryList(query["type"]) {
+		sub.Types = append(sub.Types, types.ResourceType(resourceType))
+	}
+
+	for _, pattern := range sub.Groups {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return sub, ErrInvalidSubscription
+		}
+	}
+
+	return sub, nil
+}
+
+func queryList(values []string) []string {
+	var out []string
+	for _, value := range values {
+		for _, part := range strings.Split(value, ",") {
+			if part = strings.TrimSpace(part); part != "" {
+				out = append(out, part)
+			}
+		}
+	}
+
+	return out
+}
@@ -0,0 +1,101 @@
+package operator
+
+import (
+	"errors"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/lamlv2305/sentinel/types"
+)
+
+func TestMatchGroup(t *testing.T) {
+	tests := []struct {
+		pattern string
+		group   string
+		want    bool
+	}{
+		{pattern: "payments", group: "payments", want: true},
+		{pattern: "payments", group: "payments/eu", want: false},
+		{pattern: "*", group: "anything", want: true},
+		{pattern: "**", group: "a/b/c", want: true},
+		{pattern: "payments/*", group: "payments/eu", want: true},
+		{pattern: "payments/*", group: "payments/eu/de", want: false},
+		{pattern: "payments/*", group: "payments", want: false},
+		{pattern: "payments/**", group: "payments", want: true},
+		{pattern: "payments/**", group: "payments/eu", want: true},
+		{pattern: "payments/**", group: "payments/eu/de", want: true},
+		{pattern: "payments/**", group: "paymentsXYZ", want: false},
+		{pattern: "payments/**", group: "payments-eu/x", want: false},
+		{pattern: "payments**", group: "payments/eu", want: true},
+		{pattern: "payments**", group: "paymentsXYZ", want: false},
+		{pattern: "pay**", group: "payroll", want: false},
+		{pattern: "config/**", group: "", want: false},
+		{pattern: "[", group: "[", want: true},
+		{pattern: "[", group: "x", want: false},
+	}
+
+	for _, tt := range tests {
+		if got := matchGroup(tt.pattern, tt.group); got != tt.want {
+			t.Errorf("matchGroup(%q, %q) = %v, want %v", tt.pattern, tt.group, got, tt.want)
+		}
+	}
+}
+
+func TestSubscriptionMatches(t *testing.T) {
+	resource := types.Resource{ResourceId: "db", Group: "payments/eu", ResourceType: "secret"}
+
+	tests := []struct {
+		name string
+		sub  Subscription
+		want bool
+	}{
+		{name: "empty", sub: Subscription{}, want: true},
+		{name: "group", sub: Subscription{Groups: []string{"billing", "payments/**"}}, want: true},
+		{name: "other group", sub: Subscription{Groups: []string{"billing"}}, want: false},
+		{name: "group prefix without boundary", sub: Subscription{Groups: []string{"pay**"}}, want: false},
+		{name: "resource", sub: Subscription{ResourceIds: []string{"cache", "db"}}, want: true},
+		{name: "other resource", sub: Subscription{ResourceIds: []string{"cache"}}, want: false},
+		{name: "type", sub: Subscription{Types: []types.ResourceType{"secret"}}, want: true},
+		{name: "other type", sub: Subscription{Types: []types.ResourceType{"config"}}, want: false},
+		{name: "every list must match", sub: Subscription{Groups: []string{"payments/*"}, ResourceIds: []string{"cache"}}, want: false},
+		{name: "all lists match", sub: Subscription{Groups: []string{"payments/*"}, ResourceIds: []string{"db"}, Types: []types.ResourceType{"secret"}}, want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.sub.Matches(resource); got != tt.want {
+				t.Errorf("Matches = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseSubscription(t *testing.T) {
+	r := httptest.NewRequest("GET", "/sse?group=payments/**,billing&group=ops&resource=db&type=secret,%20config", nil)
+
+	sub, err := parseSubscription(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !slices.Equal(sub.Groups, []string{"payments/**", "billing", "ops"}) ||
+		!slices.Equal(sub.ResourceIds, []string{"db"}) ||
+		!slices.Equal(sub.Types, []types.ResourceType{"secret", "config"}) {
+		t.Errorf("subscription = %+v", sub)
+	}
+
+	if _, err := parseSubscription(httptest.NewRequest("GET", "/sse?group=[", nil)); !errors.Is(err, ErrInvalidSubscription) {
+		t.Errorf("malformed group: error = %v, want %v", err, ErrInvalidSubscription)
+	}
+}
+
+func TestPrincipalAllowsGroup(t *testing.T) {
+	p := &Principal{Groups: []string{"payments/**"}}
+
+	for group, want := range map[string]bool{"payments": true, "payments/eu": true, "paymentsXYZ": false, "billing": false} {
+		if got := p.AllowsGroup(group); got != want {
+			t.Errorf("AllowsGroup(%q) = %v, want %v", group, got, want)
+		}
+	}
+}
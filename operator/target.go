@@ -0,0 +1,111 @@
+package operator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/lamlv2305/sentinel/types"
+)
+
+var ErrInvalidLabel = errors.New("invalid label")
+
+// Reserved labels holding the metadata of agents. Clients cannot declare
+// labels with the agent. prefix.
+const (
+	LabelHostname         = "agent.hostname"
+	LabelVersion          = "agent.version"
+	LabelApp              = "agent.app"
+	LabelCapabilityPrefix = "agent.capability." // followed by the capability, set to "true"
+
+	reservedLabelPrefix = "agent."
+)
+
+// Target narrows a broadcast to some clients of the event's project, for
+// staged rollouts and debugging. The zero Target addresses the whole project.
+type Target struct {
+	// ConnectionIds, when set, address these connections only
+	ConnectionIds []string
+
+	// Selector addresses the clients whose labels it matches
+	Selector Selector
+}
+
+// IsZero reports whether the target addresses the whole project
+func (t Target) IsZero() bool {
+	return len(t.ConnectionIds) == 0 && t.Selector.IsZero()
+}
+
+func (t Target) matches(c *Client) bool {
+	if len(t.ConnectionIds) > 0 && !slices.Contains(t.ConnectionIds, c.Id) {
+		return false
+	}
+
+	return t.Selector.Matches(c.selectable)
+}
+
+// BroadcastTo is Broadcast restricted to the clients addressed by the target.
+// Targeted events are kept in the journal like any other, and only replayed
+// to clients the target addresses.
+func (s *SSE) BroadcastTo(ctx context.Context, event types.ChangedEvent, target Target) (DeliveryReport, error) {
+	return s.broadcast(ctx, event, target)
+}
+
+// parseLabels reads the labels a connecting client declares, from the labels
+// header or the label query parameter, as comma separated key=value pairs
+func parseLabels(r *http.Request) (map[string]string, error) {
+	values := queryList(r.Header.Values(types.HeaderLabels))
+	if len(values) == 0 {
+		values = queryList(r.URL.Query()["label"])
+	}
+
+	if len(values) == 0 {
+		return nil, nil
+	}
+
+	labels := make(map[string]string, len(values))
+	for _, pair := range values {
+		key, value, _ := strings.Cut(pair, "=")
+		key = strings.TrimSpace(key)
+		if key == "" || strings.ContainsAny(key, "!=<>") || strings.HasPrefix(key, reservedLabelPrefix) {
+			return nil, ErrInvalidLabel
+		}
+		labels[key] = strings.TrimSpace(value)
+	}
+
+	return labels, nil
+}
+
+// parseMetadata reads the metadata a connecting agent sends in the agent
+// header
+func parseMetadata(r *http.Request) (types.AgentMetadata, error) {
+	header := r.Header.Get(types.HeaderAgent)
+	if header == "" {
+		return types.AgentMetadata{}, nil
+	}
+
+	metadata, err := types.ParseAgentMetadata(header)
+	if err != nil {
+		return types.AgentMetadata{}, fmt.Errorf("failed to parse agent metadata: %w", err)
+	}
+
+	return metadata, nil
+}
+
+// parseTarget reads a target from the connection and selector query
+// parameters of a publish request
+func parseTarget(r *http.Request) (Target, error) {
+	query := r.URL.Query()
+	selector, err := ParseSelector(query.Get("selector"))
+	if err != nil {
+		return Target{}, err
+	}
+
+	return Target{
+		ConnectionIds: queryList(query["connection"]),
+		Selector:      selector,
+	}, nil
+}
@@ -0,0 +1,161 @@
+package operator
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"testing"
+
+	"github.com/lamlv2305/sentinel/types"
+)
+
+// targetedIds returns the sorted ids of the clients the shard finds for the
+// selector, before the selector itself is applied
+func targetedIds(t *testing.T, s *shard, selector string) []string {
+	t.Helper()
+
+	sel, err := ParseSelector(selector)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var ids []string
+	for _, client := range s.targeted(Target{Selector: sel}) {
+		ids = append(ids, client.Id)
+	}
+	slices.Sort(ids)
+
+	return ids
+}
+
+func TestShardLabelIndex(t *testing.T) {
+	h := defaultHub()
+
+	newClient := func(id string, labels map[string]string, projects ...string) *Client {
+		client := NewClient(id, projects[0])
+		client.Projects = projects
+		client.Labels = labels
+		client.Metadata.Version = "1.4"
+		return client
+	}
+
+	eu := newClient("eu", map[string]string{"region": "eu"}, "billing", "payroll")
+	us := newClient("us", map[string]string{"region": "us"}, "billing")
+	eu2 := newClient("eu2", map[string]string{"region": "eu", "canary": "true"}, "billing")
+	for _, client := range []*Client{eu, us, eu2} {
+		h.add(client)
+	}
+
+	billing, payroll := h.projects["billing"], h.projects["payroll"]
+	tests := []struct {
+		name     string
+		shard    *shard
+		selector string
+		want     []string
+	}{
+		{name: "indexed label", shard: billing, selector: "region=eu", want: []string{"eu", "eu2"}},
+		{name: "other value", shard: billing, selector: "region=us", want: []string{"us"}},
+		{name: "unknown value", shard: billing, selector: "region=ap"},
+		{name: "metadata", shard: billing, selector: "agent.version=1.4", want: []string{"eu", "eu2", "us"}},
+		{name: "first equality is looked up", shard: billing, selector: "canary,region=eu", want: []string{"eu", "eu2"}},
+		{name: "no equality scans the project", shard: billing, selector: "region!=us", want: []string{"eu", "eu2", "us"}},
+		{name: "other project", shard: payroll, selector: "region=eu", want: []string{"eu"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := targetedIds(t, tt.shard, tt.selector); !slices.Equal(got, tt.want) {
+				t.Errorf("targeted = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	h.remove(eu)
+	if got := targetedIds(t, billing, "region=eu"); !slices.Equal(got, []string{"eu2"}) {
+		t.Errorf("targeted after a removal = %v, want [eu2]", got)
+	}
+	if _, ok := h.projects["payroll"]; ok {
+		t.Error("project without clients was kept")
+	}
+
+	h.remove(us)
+	if _, ok := billing.labels["region"]["us"]; ok {
+		t.Error("label value without clients was kept")
+	}
+
+	h.remove(eu2)
+	if len(billing.labels) != 0 {
+		t.Errorf("labels left after every client was removed: %v", billing.labels)
+	}
+
+	// Removing twice leaves the index alone
+	h.remove(eu2)
+}
+
+func TestBroadcastTo(t *testing.T) {
+	s := NewSSE(http.NewServeMux(), "/sse")
+
+	newClient := func(id string, labels map[string]string, version string) *Client {
+		client := NewClient(id, "billing")
+		client.Principal = &Principal{Subject: id}
+		client.Labels = labels
+		client.Metadata.Version = version
+		s.hub.add(client)
+		return client
+	}
+
+	clients := map[string]*Client{
+		"eu-old": newClient("eu-old", map[string]string{"region": "eu"}, "1.4"),
+		"eu-new": newClient("eu-new", map[string]string{"region": "eu", "canary": "true"}, "1.10"),
+		"us":     newClient("us", map[string]string{"region": "us"}, "1.10"),
+	}
+
+	tests := []struct {
+		name        string
+		connections []string
+		selector    string
+		want        []string
+	}{
+		{name: "whole project", want: []string{"eu-new", "eu-old", "us"}},
+		{name: "equality", selector: "region=eu", want: []string{"eu-new", "eu-old"}},
+		{name: "equality and version", selector: "region=eu,agent.version>=1.5", want: []string{"eu-new"}},
+		{name: "version only", selector: "agent.version<1.5", want: []string{"eu-old"}},
+		{name: "label missing", selector: "!canary", want: []string{"eu-old", "us"}},
+		{name: "connections", connections: []string{"us", "eu-old", "gone"}, want: []string{"eu-old", "us"}},
+		{name: "connections and selector", connections: []string{"us", "eu-old"}, selector: "region=eu", want: []string{"eu-old"}},
+		{name: "nothing matches", selector: "region=ap"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sel, err := ParseSelector(tt.selector)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			event := types.ChangedEvent{Resource: types.Resource{ProjectId: "billing", ResourceId: "config", Version: tt.name}}
+			report, err := s.BroadcastTo(context.Background(), event, Target{ConnectionIds: tt.connections, Selector: sel})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if report.Targeted != len(tt.want) || report.Enqueued != len(tt.want) {
+				t.Errorf("report = %+v, want %d targeted", report, len(tt.want))
+			}
+
+			var got []string
+			for id, client := range clients {
+				if versions := received(t, client); len(versions) > 0 {
+					if !slices.Equal(versions, []string{tt.name}) {
+						t.Errorf("%s received %v", id, versions)
+					}
+					got = append(got, id)
+				}
+			}
+			slices.Sort(got)
+
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("delivered to %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,146 @@
+package operator
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/goccy/go-json"
+)
+
+// ticket is a short-lived, single-use, project-scoped stand-in for a real
+// credential, for clients such as browser EventSource that cannot send headers
+type ticket struct {
+	apikey    string
+	projects  []string
+	expiresAt time.Time
+}
+
+type ticketStore struct {
+	endpoint string
+	ttl      time.Duration
+
+	mu      sync.Mutex
+	tickets map[string]ticket
+}
+
+func newTicketStore(endpoint string, ttl time.Duration) *ticketStore {
+	return &ticketStore{
+		endpoint: endpoint,
+		ttl:      ttl,
+		tickets:  make(map[string]ticket),
+	}
+}
+
+// issue creates a ticket standing in for the apikey on the given projects
+func (t *ticketStore) issue(apikey string, projects []string) (string, time.Time, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", time.Time{}, err
+	}
+
+	id := base64.RawURLEncoding.EncodeToString(raw)
+	expiresAt := time.Now().Add(t.ttl)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.tickets[id] = ticket{
+		apikey:    apikey,
+		projects:  projects,
+		expiresAt: expiresAt,
+	}
+
+	return id, expiresAt, nil
+}
+
+// consume redeems a ticket once. It fails for unknown, expired or already
+// used tickets.
+func (t *ticketStore) consume(id string) (ticket, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	tk, ok := t.tickets[id]
+	if !ok {
+		return ticket{}, false
+	}
+
+	delete(t.tickets, id)
+	if time.Now().After(tk.expiresAt) {
+		return ticket{}, false
+	}
+
+	return tk, true
+}
+
+// cleanup drops expired tickets that were never redeemed
+func (t *ticketStore) cleanup() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	for id, tk := range t.tickets {
+		if now.After(tk.expiresAt) {
+			delete(t.tickets, id)
+		}
+	}
+}
+
+type ticketResponse struct {
+	Ticket    string    `json:"ticket"`
+	Project   string    `json:"project"`
+	Projects  []string  `json:"projects"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// IssueTicket exchanges the credential of a POST request, sent in headers,
+// for a stream ticket the client passes as ?ticket= when connecting.
+func (s *SSE) IssueTicket(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Headers", s.credentials.allowedHeaders())
+	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+
+	switch r.Method {
+	case http.MethodOptions:
+		w.WriteHeader(http.StatusNoContent)
+		return
+	case http.MethodPost:
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Tickets are never issued for query credentials
+	apikey, projects := credentialSource{
+		apikeyHeader:  s.credentials.apikeyHeader,
+		projectHeader: s.credentials.projectHeader,
+	}.extract(r)
+
+	principal, status := s.verify(r, apikey, projects)
+	if status == http.StatusOK && !s.authorizeAll(principal, ActionSubscribe, projects) {
+		status = http.StatusForbidden
+	}
+	if status != http.StatusOK {
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+
+	id, expiresAt, err := s.tickets.issue(apikey, projects)
+	if err != nil {
+		slog.Error("Failed to issue stream ticket", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	_ = json.NewEncoder(w).Encode(ticketResponse{
+		Ticket:    id,
+		Project:   projects[0],
+		Projects:  projects,
+		ExpiresAt: expiresAt,
+	})
+}
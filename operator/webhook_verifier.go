@@ -0,0 +1,280 @@
+package operator
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/goccy/go-json"
+)
+
+var (
+	ErrCredentialDenied   = errors.New("webhook: credential denied")
+	ErrWebhookUnavailable = errors.New("webhook: verifier unavailable")
+)
+
+// maxWebhookCacheEntries bounds the cache; expired entries are purged beyond it
+const maxWebhookCacheEntries = 10000
+
+type webhookRequest struct {
+	Credential string `json:"credential"`
+	Project    string `json:"project"`
+}
+
+// webhookResponse is what the auth service answers with status 200. Status
+// 401 and 403 deny the credential; any other status counts as a failure.
+type webhookResponse struct {
+	Allowed     bool              `json:"allowed"`
+	Subject     string            `json:"subject"`
+	KeyId       string            `json:"key_id,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	Projects    []string          `json:"projects,omitempty"`
+	Groups      []string          `json:"groups,omitempty"`
+	Permissions []Permission      `json:"permissions,omitempty"`
+}
+
+type webhookCacheEntry struct {
+	principal *Principal // nil for a denied credential
+	expiresAt time.Time
+}
+
+// WebhookVerifier delegates credential checks to an HTTP endpoint. Answers
+// are cached, calls are bounded by a timeout, and a circuit breaker stops
+// calling the endpoint for a while after consecutive failures. Its Verify
+// method is a CredentialVerifier.
+type WebhookVerifier struct {
+	url         string
+	client      *http.Client
+	headers     map[string]string
+	timeout     time.Duration
+	positiveTTL time.Duration
+	negativeTTL time.Duration
+	maxFailures int
+	cooldown    time.Duration
+	now         func() time.Time
+
+	mu        sync.Mutex
+	cache     map[[32]byte]webhookCacheEntry
+	failures  int
+	openUntil time.Time
+}
+
+type WebhookOption func(*WebhookVerifier)
+
+// WithWebhookHTTPClient sets the client used to call the endpoint
+func WithWebhookHTTPClient(client *http.Client) WebhookOption {
+	return func(v *WebhookVerifier) {
+		v.client = client
+	}
+}
+
+// WithWebhookHeader sends a header with every call, e.g. the operator's own
+// credential for the auth service
+func WithWebhookHeader(key, value string) WebhookOption {
+	return func(v *WebhookVerifier) {
+		v.headers[key] = value
+	}
+}
+
+// WithWebhookTimeout bounds each call to the endpoint
+func WithWebhookTimeout(timeout time.Duration) WebhookOption {
+	return func(v *WebhookVerifier) {
+		v.timeout = timeout
+	}
+}
+
+// WithWebhookCacheTTL sets how long accepted and denied credentials are
+// cached; zero disables caching of that outcome
+func WithWebhookCacheTTL(positive, negative time.Duration) WebhookOption {
+	return func(v *WebhookVerifier) {
+		v.positiveTTL = positive
+		v.negativeTTL = negative
+	}
+}
+
+// WithWebhookCircuitBreaker opens the circuit after maxFailures consecutive
+// failed calls; while open, uncached credentials fail fast for cooldown
+func WithWebhookCircuitBreaker(maxFailures int, cooldown time.Duration) WebhookOption {
+	return func(v *WebhookVerifier) {
+		v.maxFailures = maxFailures
+		v.cooldown = cooldown
+	}
+}
+
+func NewWebhookVerifier(url string, opts ...WebhookOption) *WebhookVerifier {
+	v := &WebhookVerifier{
+		url:         url,
+		client:      http.DefaultClient,
+		headers:     make(map[string]string),
+		timeout:     2 * time.Second,
+		positiveTTL: 1 * time.Minute,
+		negativeTTL: 10 * time.Second,
+		maxFailures: 5,
+		cooldown:    30 * time.Second,
+		now:         time.Now,
+		cache:       make(map[[32]byte]webhookCacheEntry),
+	}
+
+	for _, opt := range opts {
+		opt(v)
+	}
+
+	return v
+}
+
+// Verify implements CredentialVerifier.
+func (v *WebhookVerifier) Verify(ctx context.Context, apikey string, project string) (*Principal, error) {
+	// Raw credentials are never kept in memory, only their hash
+	key := sha256.Sum256([]byte(apikey + "\x00" + project))
+
+	if entry, ok := v.cached(key); ok {
+		if entry.principal == nil {
+			return nil, ErrCredentialDenied
+		}
+		return entry.principal, nil
+	}
+
+	if !v.allow() {
+		return nil, ErrWebhookUnavailable
+	}
+
+	principal, err := v.call(ctx, apikey, project)
+	switch {
+	case err == nil:
+		v.succeeded()
+		v.store(key, principal, v.positiveTTL)
+		return principal, nil
+
+	case errors.Is(err, ErrCredentialDenied):
+		v.succeeded()
+		v.store(key, nil, v.negativeTTL)
+		return nil, err
+
+	default:
+		v.failed()
+		return nil, fmt.Errorf("%w: %w", ErrWebhookUnavailable, err)
+	}
+}
+
+func (v *WebhookVerifier) call(ctx context.Context, apikey, project string) (*Principal, error) {
+	ctx, cancel := context.WithTimeout(ctx, v.timeout)
+	defer cancel()
+
+	body, err := json.Marshal(webhookRequest{Credential: apikey, Project: project})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, v.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range v.headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return nil, ErrCredentialDenied
+	default:
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	var answer webhookResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&answer); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	if !answer.Allowed {
+		return nil, ErrCredentialDenied
+	}
+
+	return &Principal{
+		Subject:     answer.Subject,
+		KeyId:       answer.KeyId,
+		Labels:      answer.Labels,
+		Projects:    answer.Projects,
+		Groups:      answer.Groups,
+		Permissions: answer.Permissions,
+	}, nil
+}
+
+func (v *WebhookVerifier) cached(key [32]byte) (webhookCacheEntry, bool) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	entry, ok := v.cache[key]
+	if !ok {
+		return entry, false
+	}
+
+	if !v.now().Before(entry.expiresAt) {
+		delete(v.cache, key)
+		return entry, false
+	}
+
+	return entry, true
+}
+
+func (v *WebhookVerifier) store(key [32]byte, principal *Principal, ttl time.Duration) {
+	if ttl <= 0 {
+		return
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	now := v.now()
+	if len(v.cache) >= maxWebhookCacheEntries {
+		for k, entry := range v.cache {
+			if !now.Before(entry.expiresAt) {
+				delete(v.cache, k)
+			}
+		}
+	}
+
+	if len(v.cache) < maxWebhookCacheEntries {
+		v.cache[key] = webhookCacheEntry{principal: principal, expiresAt: now.Add(ttl)}
+	}
+}
+
+// allow reports whether the circuit lets a call through. Once the cooldown
+// is over, calls are let through again; the next failure reopens it.
+func (v *WebhookVerifier) allow() bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	return !v.now().Before(v.openUntil)
+}
+
+func (v *WebhookVerifier) succeeded() {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	v.failures = 0
+}
+
+func (v *WebhookVerifier) failed() {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	v.failures++
+	if v.maxFailures > 0 && v.failures >= v.maxFailures {
+		v.openUntil = v.now().Add(v.cooldown)
+	}
+}
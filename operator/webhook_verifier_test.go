@@ -0,0 +1,158 @@
+package operator
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/goccy/go-json"
+)
+
+func TestWebhookVerifier(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		subject string
+		err     error
+	}{
+		{
+			name: "allowed",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				var req webhookRequest
+				if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Credential != "secret" || req.Project != "billing" {
+					http.Error(w, "bad request", http.StatusBadRequest)
+					return
+				}
+				_ = json.NewEncoder(w).Encode(webhookResponse{Allowed: true, Subject: "svc", Projects: []string{"billing"}})
+			},
+			subject: "svc",
+		},
+		{
+			name: "denied by status",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusForbidden)
+			},
+			err: ErrCredentialDenied,
+		},
+		{
+			name: "denied by answer",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				_ = json.NewEncoder(w).Encode(webhookResponse{Allowed: false})
+			},
+			err: ErrCredentialDenied,
+		},
+		{
+			name: "timeout",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				select {
+				case <-r.Context().Done():
+				case <-time.After(500 * time.Millisecond):
+				}
+			},
+			err: ErrWebhookUnavailable,
+		},
+		{
+			name: "server error",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusInternalServerError)
+			},
+			err: ErrWebhookUnavailable,
+		},
+		{
+			name: "malformed answer",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				_, _ = w.Write([]byte("{not json"))
+			},
+			err: ErrWebhookUnavailable,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(tt.handler)
+			defer server.Close()
+
+			v := NewWebhookVerifier(server.URL, WithWebhookTimeout(50*time.Millisecond))
+
+			principal, err := v.Verify(context.Background(), "secret", "billing")
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("error = %v, want %v", err, tt.err)
+			}
+			if tt.err != nil {
+				return
+			}
+
+			if principal.Subject != tt.subject || !slices.Equal(principal.Projects, []string{"billing"}) {
+				t.Errorf("principal = %+v, want subject %q on billing", principal, tt.subject)
+			}
+		})
+	}
+}
+
+func TestWebhookVerifierCache(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		var req webhookRequest
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		_ = json.NewEncoder(w).Encode(webhookResponse{Allowed: req.Credential == "good", Subject: "svc"})
+	}))
+	defer server.Close()
+
+	now := time.Now()
+	v := NewWebhookVerifier(server.URL, WithWebhookCacheTTL(time.Minute, time.Second))
+	v.now = func() time.Time { return now }
+
+	for range 3 {
+		if _, err := v.Verify(context.Background(), "good", "billing"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := v.Verify(context.Background(), "bad", "billing"); !errors.Is(err, ErrCredentialDenied) {
+			t.Fatalf("error = %v, want %v", err, ErrCredentialDenied)
+		}
+	}
+	if got := calls.Load(); got != 2 {
+		t.Fatalf("calls = %d, want 2", got)
+	}
+
+	// Denials expire sooner than approvals
+	now = now.Add(2 * time.Second)
+	_, _ = v.Verify(context.Background(), "good", "billing")
+	_, _ = v.Verify(context.Background(), "bad", "billing")
+	if got := calls.Load(); got != 3 {
+		t.Fatalf("calls = %d, want 3", got)
+	}
+}
+
+func TestWebhookVerifierCircuitBreaker(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	now := time.Now()
+	v := NewWebhookVerifier(server.URL, WithWebhookCircuitBreaker(2, time.Minute))
+	v.now = func() time.Time { return now }
+
+	for range 4 {
+		if _, err := v.Verify(context.Background(), "secret", "billing"); !errors.Is(err, ErrWebhookUnavailable) {
+			t.Fatalf("error = %v, want %v", err, ErrWebhookUnavailable)
+		}
+	}
+	if got := calls.Load(); got != 2 {
+		t.Fatalf("calls while open = %d, want 2", got)
+	}
+
+	now = now.Add(time.Minute)
+	_, _ = v.Verify(context.Background(), "secret", "billing")
+	if got := calls.Load(); got != 3 {
+		t.Fatalf("calls after cooldown = %d, want 3", got)
+	}
+}
@@ -0,0 +1,167 @@
+package persister
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type item struct {
+	Key    string `json:"key"`
+	Value  string `json:"value"`
+	Secret bool   `json:"secret"`
+}
+
+func (i item) Id() string      { return i.Key }
+func (i item) Sensitive() bool { return i.Secret }
+
+func openTestPersister(t *testing.T, path string, opts ...SQLiteOption) *SQLitePersister[item] {
+	t.Helper()
+
+	p, err := NewSQLitePersister[item](path, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = p.Close() })
+
+	return p
+}
+
+// column returns the stored data column of a row
+func column(t *testing.T, p *SQLitePersister[item], id string) string {
+	t.Helper()
+
+	var data string
+	if err := p.db.QueryRow(`SELECT data FROM elements WHERE id = ?`, id).Scan(&data); err != nil {
+		t.Fatal(err)
+	}
+
+	return data
+}
+
+func TestEncryptionRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	key := bytes.Repeat([]byte{1}, 32)
+
+	tests := []struct {
+		name      string
+		opt       SQLiteOption
+		item      item
+		encrypted bool
+	}{
+		{name: "every row", opt: WithSQLiteEncryption(NewStaticKeyProvider("k1", key)), item: item{Key: "a", Value: "plain"}, encrypted: true},
+		{name: "sensitive row", opt: WithSQLiteKeyProvider(NewStaticKeyProvider("k1", key)), item: item{Key: "a", Value: "hunter2", Secret: true}, encrypted: true},
+		{name: "non sensitive row", opt: WithSQLiteKeyProvider(NewStaticKeyProvider("k1", key)), item: item{Key: "a", Value: "plain"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := openTestPersister(t, filepath.Join(t.TempDir(), "db.sqlite"), tt.opt)
+			if err := p.Save(ctx, tt.item); err != nil {
+				t.Fatal(err)
+			}
+
+			data := column(t, p, tt.item.Key)
+			if encrypted := strings.HasPrefix(data, "enc:k1:"); encrypted != tt.encrypted {
+				t.Errorf("stored %q, encrypted = %v, want %v", data, encrypted, tt.encrypted)
+			}
+			if tt.encrypted && strings.Contains(data, tt.item.Value) {
+				t.Errorf("stored %q holds the plaintext", data)
+			}
+
+			got, err := p.Get(ctx, tt.item.Key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.item {
+				t.Errorf("Get = %+v, want %+v", got, tt.item)
+			}
+		})
+	}
+}
+
+func TestEncryptionWrongKey(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "db.sqlite")
+
+	p := openTestPersister(t, path, WithSQLiteEncryption(NewStaticKeyProvider("k1", bytes.Repeat([]byte{1}, 32))))
+	if err := p.Save(ctx, item{Key: "a", Value: "plain"}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		opts []SQLiteOption
+		err  error
+	}{
+		{name: "other key with the same id", opts: []SQLiteOption{WithSQLiteEncryption(NewStaticKeyProvider("k1", bytes.Repeat([]byte{2}, 32)))}, err: ErrWrongKey},
+		{name: "unknown key id", opts: []SQLiteOption{WithSQLiteEncryption(NewStaticKeyProvider("k2", bytes.Repeat([]byte{1}, 32)))}, err: ErrKeyNotFound},
+		{name: "no key provider", err: ErrEncryptionRequired},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reopened := openTestPersister(t, path, tt.opts...)
+			if _, err := reopened.Get(ctx, "a"); !errors.Is(err, tt.err) {
+				t.Errorf("Get error = %v, want %v", err, tt.err)
+			}
+		})
+	}
+
+	// Rows are bound to their id, so a ciphertext moved to another row is rejected
+	if _, err := p.db.Exec(`INSERT INTO elements (id, data) VALUES ('b', ?)`, column(t, p, "a")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.Get(ctx, "b"); !errors.Is(err, ErrWrongKey) {
+		t.Errorf("swapped row: error = %v, want %v", err, ErrWrongKey)
+	}
+}
+
+func TestReencryptAfterRotation(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "db.sqlite")
+	oldKey, newKey := bytes.Repeat([]byte{1}, 32), bytes.Repeat([]byte{2}, 32)
+
+	// Written before encryption was enabled
+	plain := openTestPersister(t, path)
+	if err := plain.Save(ctx, item{Key: "legacy", Value: "plain"}); err != nil {
+		t.Fatal(err)
+	}
+
+	keys := NewStaticKeyProvider("k1", oldKey)
+	p := openTestPersister(t, path, WithSQLiteEncryption(keys))
+	items := []item{{Key: "a", Value: "1"}, {Key: "b", Value: "2", Secret: true}, {Key: "c", Value: "3"}}
+	for _, it := range items {
+		if err := p.Save(ctx, it); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	keys.AddKey("k2", newKey)
+	if err := <-p.Reencrypt(ctx, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, id := range []string{"a", "b", "c", "legacy"} {
+		if data := column(t, p, id); !strings.HasPrefix(data, "enc:k2:") {
+			t.Errorf("row %s stored as %q, want encrypted with k2", id, data)
+		}
+	}
+
+	// The old key is no longer needed
+	rotated := openTestPersister(t, path, WithSQLiteEncryption(NewStaticKeyProvider("k2", newKey)))
+	got, err := rotated.List(ctx, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 4 {
+		t.Errorf("List returned %d items, want 4", len(got))
+	}
+
+	if err := <-openTestPersister(t, path).Reencrypt(ctx, 0); !errors.Is(err, ErrEncryptionRequired) {
+		t.Errorf("Reencrypt without keys: error = %v, want %v", err, ErrEncryptionRequired)
+	}
+}
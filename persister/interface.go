@@ -12,3 +12,8 @@ type Persister[T Element] interface {
 	Get(ctx context.Context, id string) (T, error)
 	List(ctx context.Context, offset, limit int) ([]T, error)
 }
+
+// Sensitive is implemented by elements whose data must be encrypted at rest.
+type Sensitive interface {
+	Sensitive() bool
+}
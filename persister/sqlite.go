@@ -15,9 +15,40 @@ var _ Persister[Element] = (*SQLitePersister[Element])(nil)
 type SQLitePersister[T Element] struct {
 	filepath string
 	db       *sql.DB
+	cipher   *rowCipher
 }
 
-func NewSQLitePersister[T Element](filepath string) (*SQLitePersister[T], error) {
+// SQLiteOption configures a SQLitePersister
+type SQLiteOption func(*sqliteOptions)
+
+type sqliteOptions struct {
+	keys       KeyProvider
+	encryptAll bool
+}
+
+// WithSQLiteKeyProvider sets the keys used to encrypt sensitive elements at rest
+func WithSQLiteKeyProvider(keys KeyProvider) SQLiteOption {
+	return func(o *sqliteOptions) {
+		o.keys = keys
+	}
+}
+
+// WithSQLiteEncryption encrypts the data column of every row with AES-GCM.
+// Rows written before encryption was enabled stay readable and are encrypted
+// by Reencrypt.
+func WithSQLiteEncryption(keys KeyProvider) SQLiteOption {
+	return func(o *sqliteOptions) {
+		o.keys = keys
+		o.encryptAll = true
+	}
+}
+
+func NewSQLitePersister[T Element](filepath string, opts ...SQLiteOption) (*SQLitePersister[T], error) {
+	options := &sqliteOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+
 	u := url.URL{
 		Scheme: "file",
 		Path:   filepath,
@@ -44,10 +75,16 @@ func NewSQLitePersister[T Element](filepath string) (*SQLitePersister[T], error)
 		return nil, fmt.Errorf("failed to create table: %w", err)
 	}
 
-	return &SQLitePersister[T]{
+	ins := &SQLitePersister[T]{
 		filepath: u.String(),
 		db:       db,
-	}, nil
+	}
+
+	if options.keys != nil {
+		ins.cipher = &rowCipher{keys: options.keys, all: options.encryptAll}
+	}
+
+	return ins, nil
 }
 
 // Delete implements Persister.
@@ -84,17 +121,12 @@ func (s *SQLitePersister[T]) Get(ctx context.Context, id string) (T, error) {
 		return zero, fmt.Errorf("failed to get item: %w", err)
 	}
 
-	var item T
-	if err := json.Unmarshal([]byte(dataStr), &item); err != nil {
-		return zero, fmt.Errorf("failed to unmarshal item: %w", err)
-	}
-
-	return item, nil
+	return s.decode(ctx, id, dataStr)
 }
 
 // List implements Persister.
 func (s *SQLitePersister[T]) List(ctx context.Context, offset int, limit int) ([]T, error) {
-	query := `SELECT data FROM elements ORDER BY id LIMIT ? OFFSET ?`
+	query := `SELECT id, data FROM elements ORDER BY id LIMIT ? OFFSET ?`
 	rows, err := s.db.QueryContext(ctx, query, limit, offset)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query items: %w", err)
@@ -103,14 +135,14 @@ func (s *SQLitePersister[T]) List(ctx context.Context, offset int, limit int) ([
 
 	var items []T
 	for rows.Next() {
-		var dataStr string
-		if err := rows.Scan(&dataStr); err != nil {
+		var id, dataStr string
+		if err := rows.Scan(&id, &dataStr); err != nil {
 			return nil, fmt.Errorf("failed to scan row: %w", err)
 		}
 
-		var item T
-		if err := json.Unmarshal([]byte(dataStr), &item); err != nil {
-			return nil, fmt.Errorf("failed to unmarshal item: %w", err)
+		item, err := s.decode(ctx, id, dataStr)
+		if err != nil {
+			return nil, err
 		}
 
 		items = append(items, item)
@@ -130,16 +162,68 @@ func (s *SQLitePersister[T]) Close() error {
 
 // Save implements Persister.
 func (s *SQLitePersister[T]) Save(ctx context.Context, item T) error {
-	data, err := json.Marshal(item)
+	data, err := s.encode(ctx, item)
 	if err != nil {
-		return fmt.Errorf("failed to marshal item: %w", err)
+		return err
 	}
 
 	query := `INSERT OR REPLACE INTO elements (id, data) VALUES (?, ?)`
-	_, err = s.db.ExecContext(ctx, query, item.Id(), string(data))
+	_, err = s.db.ExecContext(ctx, query, item.Id(), data)
 	if err != nil {
 		return fmt.Errorf("failed to save item: %w", err)
 	}
 
 	return nil
 }
+
+// encode marshals an item, encrypting it when it is sensitive
+func (s *SQLitePersister[T]) encode(ctx context.Context, item T) (string, error) {
+	data, err := json.Marshal(item)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal item: %w", err)
+	}
+
+	if !isSensitive(item) && (s.cipher == nil || !s.cipher.all) {
+		return string(data), nil
+	}
+
+	if s.cipher == nil {
+		return "", ErrEncryptionRequired
+	}
+
+	encrypted, err := s.cipher.encrypt(ctx, item.Id(), data)
+	if err != nil {
+		return "", fmt.Errorf("failed to encrypt item: %w", err)
+	}
+
+	return encrypted, nil
+}
+
+// decode decrypts the data column if needed and unmarshals the item
+func (s *SQLitePersister[T]) decode(ctx context.Context, id string, dataStr string) (T, error) {
+	var item T
+
+	data := []byte(dataStr)
+	if isEncrypted(dataStr) {
+		if s.cipher == nil {
+			return item, ErrEncryptionRequired
+		}
+
+		decrypted, err := s.cipher.decrypt(ctx, id, dataStr)
+		if err != nil {
+			return item, fmt.Errorf("failed to decrypt item %s: %w", id, err)
+		}
+		data = decrypted
+	}
+
+	if err := json.Unmarshal(data, &item); err != nil {
+		return item, fmt.Errorf("failed to unmarshal item: %w", err)
+	}
+
+	return item, nil
+}
+
+func isSensitive(item any) bool {
+	sensitive, ok := item.(Sensitive)
+	return ok && sensitive.Sensitive()
+}
@@ -0,0 +1,103 @@
+package persister
+
+import (
+	"context"
+	"fmt"
+)
+
+// Reencrypt rewrites, in the background, every row that is not encrypted with
+// the current key of the provider. Call it after rotating the key. The
+// returned channel yields the outcome (nil on success) once all rows have
+// been visited, then closes.
+//
+// Rows are swapped only if they did not change meanwhile, so concurrent Save
+// calls always win.
+func (s *SQLitePersister[T]) Reencrypt(ctx context.Context, batchSize int) <-chan error {
+	done := make(chan error, 1)
+
+	go func() {
+		defer close(done)
+		done <- s.reencrypt(ctx, batchSize)
+	}()
+
+	return done
+}
+
+func (s *SQLitePersister[T]) reencrypt(ctx context.Context, batchSize int) error {
+	if s.cipher == nil {
+		return ErrEncryptionRequired
+	}
+
+	if batchSize <= 0 {
+		batchSize = 100
+	}
+
+	lastId := ""
+	for {
+		currentKeyId, _, err := s.cipher.keys.CurrentKey(ctx, keyScope)
+		if err != nil {
+			return fmt.Errorf("failed to get encryption key: %w", err)
+		}
+
+		query := `SELECT id, data FROM elements WHERE id > ? ORDER BY id LIMIT ?`
+		rows, err := s.db.QueryContext(ctx, query, lastId, batchSize)
+		if err != nil {
+			return fmt.Errorf("failed to query items: %w", err)
+		}
+
+		type row struct{ id, data string }
+		var batch []row
+		for rows.Next() {
+			var r row
+			if err := rows.Scan(&r.id, &r.data); err != nil {
+				rows.Close()
+				return fmt.Errorf("failed to scan row: %w", err)
+			}
+			batch = append(batch, r)
+		}
+		rows.Close()
+
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("error iterating rows: %w", err)
+		}
+
+		for _, r := range batch {
+			lastId = r.id
+
+			keyId, _, encrypted := rowKeyId(r.data)
+			if encrypted && keyId == currentKeyId {
+				continue
+			}
+
+			plaintext := []byte(r.data)
+			if encrypted {
+				plaintext, err = s.cipher.decrypt(ctx, r.id, r.data)
+				if err != nil {
+					return fmt.Errorf("failed to decrypt item %s: %w", r.id, err)
+				}
+			} else if !s.cipher.all {
+				item, err := s.decode(ctx, r.id, r.data)
+				if err != nil {
+					return err
+				}
+				if !isSensitive(item) {
+					continue
+				}
+			}
+
+			data, err := s.cipher.encrypt(ctx, r.id, plaintext)
+			if err != nil {
+				return fmt.Errorf("failed to encrypt item %s: %w", r.id, err)
+			}
+
+			query := `UPDATE elements SET data = ? WHERE id = ? AND data = ?`
+			if _, err := s.db.ExecContext(ctx, query, data, r.id, r.data); err != nil {
+				return fmt.Errorf("failed to update item %s: %w", r.id, err)
+			}
+		}
+
+		if len(batch) < batchSize {
+			return nil
+		}
+	}
+}
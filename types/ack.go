@@ -0,0 +1,29 @@
+package types
+
+// CapabilityAck is declared by agents that acknowledge the resource versions
+// they apply
+const CapabilityAck = "ack"
+
+// AckStatus is the outcome of applying a resource version on an agent
+type AckStatus string
+
+const (
+	AckApplied  AckStatus = "applied"  // persisted
+	AckRejected AckStatus = "rejected" // refused by the agent, e.g. by a validator
+	AckFailed   AckStatus = "failed"   // could not be persisted
+)
+
+// Valid reports whether the status is one of the known outcomes
+func (s AckStatus) Valid() bool {
+	return s == AckApplied || s == AckRejected || s == AckFailed
+}
+
+// Ack is sent by an agent to the operator once it handled a resource version
+type Ack struct {
+	ConnectionId string    `json:"connection_id"`
+	ProjectId    string    `json:"project_id"`
+	ResourceId   string    `json:"resource_id"`
+	Version      string    `json:"version"`
+	Status       AckStatus `json:"status"`
+	Error        string    `json:"error,omitempty"`
+}
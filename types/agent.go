@@ -0,0 +1,67 @@
+package types
+
+import (
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// AgentMetadata describes the agent on the other end of a connection. Agents
+// send it on connect in the agent header, encoded by Encode.
+type AgentMetadata struct {
+	Hostname     string   `json:"hostname,omitempty"`
+	Version      string   `json:"version,omitempty"`
+	App          string   `json:"app,omitempty"`
+	Capabilities []string `json:"capabilities,omitempty"`
+}
+
+// IsZero reports whether no metadata was sent
+func (m AgentMetadata) IsZero() bool {
+	return m.Hostname == "" && m.Version == "" && m.App == "" && len(m.Capabilities) == 0
+}
+
+// HasCapability reports whether the agent declared the capability
+func (m AgentMetadata) HasCapability(capability string) bool {
+	return slices.Contains(m.Capabilities, capability)
+}
+
+// Encode formats the metadata as a URL encoded query, as sent in the agent
+// header
+func (m AgentMetadata) Encode() string {
+	values := make(url.Values)
+	if m.Hostname != "" {
+		values.Set("hostname", m.Hostname)
+	}
+	if m.Version != "" {
+		values.Set("version", m.Version)
+	}
+	if m.App != "" {
+		values.Set("app", m.App)
+	}
+	for _, capability := range m.Capabilities {
+		values.Add("capability", capability)
+	}
+
+	return values.Encode()
+}
+
+// ParseAgentMetadata decodes metadata formatted by Encode
+func ParseAgentMetadata(s string) (AgentMetadata, error) {
+	values, err := url.ParseQuery(s)
+	if err != nil {
+		return AgentMetadata{}, err
+	}
+
+	m := AgentMetadata{
+		Hostname: strings.TrimSpace(values.Get("hostname")),
+		Version:  strings.TrimSpace(values.Get("version")),
+		App:      strings.TrimSpace(values.Get("app")),
+	}
+	for _, capability := range values["capability"] {
+		if capability = strings.TrimSpace(capability); capability != "" && !m.HasCapability(capability) {
+			m.Capabilities = append(m.Capabilities, capability)
+		}
+	}
+
+	return m, nil
+}
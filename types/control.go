@@ -0,0 +1,14 @@
+package types
+
+// ControlType names the SSE event type of operator control messages, which
+// are sent besides resource changes
+type ControlType string
+
+const (
+	ControlConnected ControlType = "connected"
+	ControlRevoked   ControlType = "revoked"
+
+	// ControlDisconnected closes a connection the agent may open again, e.g.
+	// when an admin drops it
+	ControlDisconnected ControlType = "disconnected"
+)
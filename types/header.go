@@ -0,0 +1,18 @@
+package types
+
+// Headers shared by the operator and agents.
+const (
+	HeaderAPIKey  = "X-Sentinel-Apikey"
+	HeaderProject = "X-Sentinel-Project"
+
+	// HeaderCoalesce asks the operator to deliver only the latest pending
+	// event per resource to a client that falls behind
+	HeaderCoalesce = "X-Sentinel-Coalesce"
+
+	// HeaderLabels declares the labels of an agent, as comma separated
+	// key=value pairs, for targeted delivery
+	HeaderLabels = "X-Sentinel-Labels"
+
+	// HeaderAgent carries the AgentMetadata of an agent
+	HeaderAgent = "X-Sentinel-Agent"
+)
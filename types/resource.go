@@ -3,6 +3,7 @@ package types
 import (
 	"encoding/base64"
 	"encoding/json"
+	"log/slog"
 )
 
 type ResourceType string
@@ -13,14 +14,29 @@ const (
 	ResourceTypeJsonArray  ResourceType = "json_array"
 	ResourceTypeBinary     ResourceType = "binary"
 	ResourceTypeImage      ResourceType = "image"
+	ResourceTypeSecret     ResourceType = "secret"
 )
 
+// RedactedData replaces the data of secret resources wherever they are shown.
+const RedactedData = "[REDACTED]"
+
 type Resource struct {
 	ResourceId   string       `json:"resource_id"`
 	ProjectId    string       `json:"project_id"`
 	Group        string       `json:"group,omitempty"`
+	Version      string       `json:"version,omitempty"` // opaque, set by the operator when publishers leave it empty
 	ResourceType ResourceType `json:"resource_type"`
 	Data         []byte       `json:"data,omitempty"`
+	Envelope     *Envelope    `json:"envelope,omitempty"`
+}
+
+// Envelope holds what is needed to decrypt a sealed Resource.Data: the id of
+// the project key that wrapped the data key, the wrapped data key itself and
+// the nonce used to encrypt the payload.
+type Envelope struct {
+	KeyId      string `json:"key_id"`
+	WrappedKey []byte `json:"wrapped_key"`
+	Nonce      []byte `json:"nonce"`
 }
 
 func (r Resource) Encode() (string, error) {
@@ -32,6 +48,49 @@ func (r Resource) Encode() (string, error) {
 	return base64.StdEncoding.EncodeToString(data), nil
 }
 
+// IsSealed reports whether Data is encrypted.
+func (r Resource) IsSealed() bool {
+	return r.Envelope != nil
+}
+
+// IsSecret reports whether the resource data must never be shown.
+func (r Resource) IsSecret() bool {
+	return r.ResourceType == ResourceTypeSecret
+}
+
+// Sensitive implements persister.Sensitive; secrets are encrypted at rest.
+func (r Resource) Sensitive() bool {
+	return r.IsSecret()
+}
+
+// Redacted returns a copy that is safe to show in logs, APIs and CLI output.
+func (r Resource) Redacted() Resource {
+	if r.IsSecret() {
+		r.Data = []byte(RedactedData)
+		r.Envelope = nil
+	}
+
+	return r
+}
+
+// LogValue implements slog.LogValuer so secret data never reaches the logs.
+func (r Resource) LogValue() slog.Value {
+	data := string(r.Data)
+	if r.IsSecret() {
+		data = RedactedData
+	} else if r.IsSealed() {
+		data = "[SEALED]"
+	}
+
+	return slog.GroupValue(
+		slog.String("resource_id", r.ResourceId),
+		slog.String("project_id", r.ProjectId),
+		slog.String("group", r.Group),
+		slog.String("resource_type", string(r.ResourceType)),
+		slog.String("data", data),
+	)
+}
+
 // Id implements persister.Element.
 func (r Resource) Id() string {
 	return r.ResourceId